@@ -0,0 +1,162 @@
+package csvjson // import "goex/ltser/csvjson"
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Encodings supported by Detect.
+const (
+	UTF8        = "utf-8"
+	Latin1      = "latin1"
+	Windows1252 = "windows-1252"
+)
+
+const sampleSize = 64 * 1024
+
+var (
+	utf8BOM        = []byte{0xEF, 0xBB, 0xBF}
+	commaCandidate = []rune{',', ';', '\t', '|'}
+)
+
+// A Dialect describes the format of a csv input. Zero values mean auto detection.
+type Dialect struct {
+	Comma    rune   // Fields delimiter.
+	Encoding string // Input encoding (UTF8, Latin1 or Windows1252).
+	Decimal  rune   // Decimal separator ('.' or ',').
+	BOM      bool   // Whether the input starts with a UTF-8 byte order mark (detected only).
+}
+
+func (d Dialect) String() string {
+	return fmt.Sprintf("delimiter %q, encoding %s, decimal separator %q, BOM %v", d.Comma, d.Encoding, d.Decimal, d.BOM)
+}
+
+// Detect sniffs the first bytes of r to fill the zero fields of d. It returns the
+// detected dialect and a reader of the UTF-8 content of r, without byte order mark.
+// Inputs that are not valid UTF-8 are assumed to be Windows-1252 encoded.
+func Detect(r io.Reader, d Dialect) (io.Reader, Dialect, error) {
+	br := bufio.NewReaderSize(r, sampleSize)
+	sample, err := br.Peek(sampleSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, d, err
+	}
+
+	if bytes.HasPrefix(sample, utf8BOM) {
+		br.Discard(len(utf8BOM))
+		sample = sample[len(utf8BOM):]
+		d.BOM = true
+		if d.Encoding == "" {
+			d.Encoding = UTF8
+		}
+	}
+	if i := bytes.LastIndexByte(sample, '\n'); i >= 0 && i < len(sample)-1 {
+		sample = sample[:i+1] // Only complete lines.
+	}
+
+	if d.Encoding == "" {
+		d.Encoding = UTF8
+		if !utf8.Valid(sample) {
+			d.Encoding = Windows1252
+		}
+	}
+	if d.Comma == 0 {
+		d.Comma = detectComma(sample)
+	}
+	if d.Decimal == 0 {
+		d.Decimal = detectDecimal(sample, d.Comma)
+	}
+
+	var out io.Reader = br
+	switch strings.ToLower(d.Encoding) {
+	case UTF8, "utf8":
+	case Latin1, "iso-8859-1":
+		out = charmap.ISO8859_1.NewDecoder().Reader(br)
+	case Windows1252, "cp1252":
+		out = charmap.Windows1252.NewDecoder().Reader(br)
+	default:
+		return nil, d, fmt.Errorf("unsupported encoding %q", d.Encoding)
+	}
+
+	return out, d, nil
+}
+
+// detectComma returns the candidate delimiter found the same number of times
+// on most lines (and the most times, on ties). Default is ','.
+func detectComma(sample []byte) rune {
+	lines := strings.Split(strings.TrimRight(string(sample), "\r\n"), "\n")
+
+	best, bestScore, bestCount := ',', 0, 0
+	for _, c := range commaCandidate {
+		counts := make(map[int]int)
+		for _, l := range lines {
+			if n := countOutsideQuotes(l, c); n > 0 {
+				counts[n]++
+			}
+		}
+		for count, score := range counts {
+			if score > bestScore || score == bestScore && count > bestCount {
+				best, bestScore, bestCount = c, score, count
+			}
+		}
+	}
+
+	return best
+}
+
+// detectDecimal returns ',' if the fields are not comma separated and at least
+// one field is a number with decimal comma. Default is '.'.
+func detectDecimal(sample []byte, comma rune) rune {
+	if comma == ',' {
+		return '.'
+	}
+	for _, l := range strings.Split(string(sample), "\n") {
+		for _, f := range strings.Split(strings.TrimRight(l, "\r"), string(comma)) {
+			if isDecimalComma(f) {
+				return ','
+			}
+		}
+	}
+	return '.'
+}
+
+func countOutsideQuotes(s string, c rune) int {
+	n, quoted := 0, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == c && !quoted:
+			n++
+		}
+	}
+	return n
+}
+
+// isDecimalComma reports whether s is a number like "-3,25".
+func isDecimalComma(s string) bool {
+	s = strings.TrimLeft(s, "+-")
+	i := strings.IndexByte(s, ',')
+	if i < 0 || i == len(s)-1 || strings.IndexByte(s[i+1:], ',') >= 0 {
+		return false
+	}
+	for _, r := range s[:i] + s[i+1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// toDecimalPoint converts numbers with decimal comma, leaving other values unchanged.
+func toDecimalPoint(s string) string {
+	if isDecimalComma(s) {
+		return strings.Replace(s, ",", ".", 1)
+	}
+	return s
+}
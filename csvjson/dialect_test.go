@@ -0,0 +1,51 @@
+package csvjson_test
+
+import (
+	"encoding/csv"
+	"goex/ltser/csvjson"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	for _, c := range []struct {
+		in      string
+		hint    csvjson.Dialect
+		dialect csvjson.Dialect
+		out     string
+	}{
+		{"\xEF\xBB\xBFtime,station,air_t_avg\n2020-01-01 00:00:00,B3,-3.25\n", csvjson.Dialect{},
+			csvjson.Dialect{Comma: ',', Encoding: csvjson.UTF8, Decimal: '.', BOM: true},
+			`{"air_t_avg":"-3.25","station":"B3","time":"2020-01-01 00:00:00"}`},
+		{"time;station;air_t_avg\n2020-01-01 00:00:00;B3;-3,25\n", csvjson.Dialect{},
+			csvjson.Dialect{Comma: ';', Encoding: csvjson.UTF8, Decimal: ','},
+			`{"air_t_avg":"-3.25","station":"B3","time":"2020-01-01 00:00:00"}`},
+		{"time\tstation\tair_t_avg\n2020-01-01 00:00:00\tB3\t-3,25 \xB0C\n", csvjson.Dialect{},
+			csvjson.Dialect{Comma: '\t', Encoding: csvjson.Windows1252, Decimal: '.'},
+			`{"air_t_avg":"-3,25 °C","station":"B3","time":"2020-01-01 00:00:00"}`},
+		{"time;station;air_t_avg\n2020-01-01 00:00:00;B3;-3,25\n", csvjson.Dialect{Decimal: '.'},
+			csvjson.Dialect{Comma: ';', Encoding: csvjson.UTF8, Decimal: '.'},
+			`{"air_t_avg":"-3,25","station":"B3","time":"2020-01-01 00:00:00"}`},
+	} {
+		in, d, err := csvjson.Detect(strings.NewReader(c.in), c.hint)
+		if err != nil {
+			t.Fatalf("Detect() returned error %v", err)
+		}
+		if d != c.dialect {
+			t.Errorf("Detect() => %v != %v", d, c.dialect)
+		}
+
+		cr := csv.NewReader(in)
+		cr.Comma = d.Comma
+		r := csvjson.NewReader(*cr)
+		r.DecimalComma = d.Decimal == ','
+
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read() returned error %v", err)
+		}
+		if string(got) != c.out {
+			t.Errorf("Read() => %s != %s", got, c.out)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package csvjson // import "goex/ltser/csvjson"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HeadersMode defines how the Reader uses the headers rows after the first one.
+type HeadersMode byte
+
+// Available headers modes.
+const (
+	FirstHeaders     HeadersMode = iota // Only the first row is used for keys, the others are skipped.
+	CompositeHeaders                    // Keys are made by joining the non empty cells of every row.
+	MetadataHeaders                     // The first row is used for keys, the others are kept as metadata.
+)
+
+var headersModeNames = []string{"first", "composite", "metadata"}
+
+func (m HeadersMode) String() string {
+	if int(m) < len(headersModeNames) {
+		return headersModeNames[m]
+	}
+	return "HeadersMode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// ParseHeadersMode returns the HeadersMode with the given name (first, composite or metadata).
+func ParseHeadersMode(s string) (HeadersMode, error) {
+	for i, n := range headersModeNames {
+		if s == n {
+			return HeadersMode(i), nil
+		}
+	}
+	return FirstHeaders, fmt.Errorf("unknown headers mode %q", s)
+}
+
+// Metadata returns the headers rows after the first one, when HeadersMode is MetadataHeaders.
+// Each row is returned as a map from json key to cell value, under the name given by MetadataNames
+// (by default "header2", "header3", ...). The result is empty until the headers have been read.
+func (r *Reader) Metadata() map[string]map[string]string {
+	return r.metadata
+}
+
+// setHeaders builds keys and metadata from the headers rows.
+func (r *Reader) setHeaders(rows [][]string) {
+	if len(rows) == 0 {
+		return
+	}
+
+	switch r.HeadersMode {
+	case CompositeHeaders:
+		r.headers = make([]string, len(rows[0]))
+		for i := range r.headers {
+			var parts []string
+			for _, row := range rows {
+				if i < len(row) && row[i] != "" {
+					parts = append(parts, row[i])
+				}
+			}
+			r.headers[i] = strings.Join(parts, r.HeadersSeparator)
+		}
+	case MetadataHeaders:
+		r.headers = rows[0]
+		r.metadata = make(map[string]map[string]string, len(rows)-1)
+		for j, row := range rows[1:] {
+			name := "header" + strconv.Itoa(j+2)
+			if j < len(r.MetadataNames) {
+				name = r.MetadataNames[j]
+			}
+			m := make(map[string]interface{}, len(row))
+			for i := 0; i < len(row) && i < len(r.headers); i++ {
+				m[r.headers[i]] = row[i]
+			}
+			if r.Schema != nil {
+				r.Schema.applyKeys(m, func(v interface{}) bool { return v == "" })
+			}
+			r.metadata[name] = make(map[string]string, len(m))
+			for k, v := range m {
+				r.metadata[name][k] = v.(string)
+			}
+		}
+	default:
+		r.headers = rows[0]
+	}
+}
@@ -0,0 +1,52 @@
+package csvjson // import "goex/ltser/csvjson"
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// inferValue converts a csv cell into the JSON value it most likely represents:
+// nil for null tokens, int64 or float64 for numbers, bool for true/false and
+// the unchanged string otherwise. Numbers with leading zeros (e.g. "007") are
+// codes rather than quantities: they are kept as strings.
+func inferValue(s string, nullValues []string) interface{} {
+	for _, n := range nullValues {
+		if s == n {
+			return nil
+		}
+	}
+
+	if hasLeadingZero(s) {
+		return s
+	}
+
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+
+	// Hexadecimal notation, NaN and infinities are valid for ParseFloat but are not JSON numbers.
+	if !strings.ContainsAny(s, "xX") {
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f
+		}
+	}
+
+	switch {
+	case strings.EqualFold(s, "true"):
+		return true
+	case strings.EqualFold(s, "false"):
+		return false
+	}
+
+	return s
+}
+
+// hasLeadingZero reports whether s, sign aside, starts with a zero followed by a digit (e.g. "007",
+// but not "0" nor "0.5").
+func hasLeadingZero(s string) bool {
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+	return len(s) > 1 && s[0] == '0' && s[1] >= '0' && s[1] <= '9'
+}
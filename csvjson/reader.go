@@ -1,4 +1,5 @@
-// Package csvjson provide a *csvjson.Reader that wraps a *csv.Reader and returs json []bytes.
+// Package csvjson provide a *csvjson.Reader that wraps a *csv.Reader and returs json []bytes,
+// and a *csvjson.Writer that converts json []bytes back into records of a *csv.Writer.
 package csvjson // import "goex/ltser/csvjson"
 
 import (
@@ -10,27 +11,73 @@ import (
 )
 
 const (
-	defHeaderRows   = 1
-	defIndentFormat = false
-	defIndent       = ""
+	defHeaderRows       = 1
+	defHeadersMode      = FirstHeaders
+	defHeadersSeparator = "_"
+	defIndentFormat     = false
+	defIndent           = ""
 )
 
+// Default values for cells converted to JSON null when types are inferred.
+var defNullValues = []string{"", "NaN"}
+
 // A Reader convert records read by a csv.Reader into json objects.
+//
+// By default every value is emitted as a JSON string. If InferTypes is true
+// (or the column is listed in InferColumns) values are emitted as JSON numbers,
+// booleans or null (for cells matching one of the NullValues) when possible.
+// If DecimalComma is true, numbers with decimal comma (e.g. "3,25") are converted to decimal point.
+// If Schema is not nil, it is applied to each object after the conversion.
+//
+// HeadersMode defines how the headers rows after the first one are used: they can
+// be skipped, joined with HeadersSeparator into composite keys, or kept as metadata
+// (see Metadata). Metadata rows listed in EmbedMetadata are added to each object.
 type Reader struct {
-	HeadersRows  uint
-	IndentFormat bool
-	Indent       string
-	cr           *csv.Reader
-	headers      []string
-	rowsCount    uint
+	HeadersRows      uint
+	HeadersMode      HeadersMode
+	HeadersSeparator string
+	MetadataNames    []string
+	EmbedMetadata    []string
+	IndentFormat     bool
+	Indent           string
+	DecimalComma     bool
+	InferTypes       bool
+	InferColumns     []string
+	NullValues       []string
+	Schema           *Schema
+	cr               *csv.Reader
+	headerRows       [][]string
+	headers          []string
+	metadata         map[string]map[string]string
+	record           []string
+	rowsCount        uint
+}
+
+// A RowError is returned by Read for rows that cannot be converted.
+type RowError struct {
+	Row    uint     // Row number, headers rows included, starting from 1.
+	Record []string // Fields of the row, nil if the row could not be parsed as csv.
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("malformed row #%v (%s)", e.Row, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *RowError) Unwrap() error {
+	return e.Err
 }
 
 // NewReader returns a new Reader that read from r.
 func NewReader(r csv.Reader) *Reader {
 	csvconvReder := new(Reader)
 	csvconvReder.HeadersRows = defHeaderRows
+	csvconvReder.HeadersMode = defHeadersMode
+	csvconvReder.HeadersSeparator = defHeadersSeparator
 	csvconvReder.IndentFormat = defIndentFormat
 	csvconvReder.Indent = defIndent
+	csvconvReder.NullValues = defNullValues
 	csvconvReder.cr = &r
 
 	return csvconvReder
@@ -38,23 +85,31 @@ func NewReader(r csv.Reader) *Reader {
 
 // Read obtains one json object from a record read from r.
 func (r *Reader) Read() ([]byte, error) {
+	m, err := r.ReadObject()
+	if err != nil {
+		return nil, err
+	}
 
-	// Read headers.
-	for r.rowsCount < r.HeadersRows {
-		record, err := r.cr.Read()
-		r.rowsCount++
-		if err != nil {
-			return nil, err
-		}
+	return r.Marshal(m)
+}
 
-		if r.rowsCount == 1 {
-			r.headers = append(r.headers, record...) // Cloning values since r.cr.ReuseRecord could be true.
-		}
+// ReadObject obtains the object of a record read from r, as it would be converted to json by Read.
+// It allows to inspect or change the object before calling Marshal.
+func (r *Reader) ReadObject() (map[string]interface{}, error) {
+	if err := r.ReadHeaders(); err != nil {
+		return nil, err
 	}
 
 	// Read data.
 	record, err := r.cr.Read()
 	r.rowsCount++
+	r.record = record
+	if pe, ok := err.(*csv.ParseError); ok {
+		if pe.Err != csv.ErrFieldCount { // Fields of rows with wrong number of fields are complete, the others may be not.
+			r.record = nil
+		}
+		return nil, &RowError{Row: r.rowsCount, Record: r.Record(), Err: err}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -65,32 +120,105 @@ func (r *Reader) Read() ([]byte, error) {
 			r.headers = append(r.headers, "column"+strconv.Itoa(i))
 		}
 	}
-	m, err := toMap(r.headers, record)
+	m, err := toMap(r.headers, record, r.convert)
 	if err != nil {
-		return nil, fmt.Errorf("malformed row #%v (%s)", r.rowsCount, err)
+		return nil, &RowError{Row: r.rowsCount, Record: r.Record(), Err: err}
+	}
+	if r.Schema != nil {
+		r.Schema.apply(m, r.isNull)
 	}
+	for _, name := range r.EmbedMetadata {
+		if md, ok := r.metadata[name]; ok {
+			m[name] = md
+		}
+	}
+
+	return m, nil
+}
+
+// ReadHeaders reads the headers rows, if not read yet. ReadObject reads them when needed: calling
+// ReadHeaders first allows to tell the input read for the headers from the one read for the first row.
+func (r *Reader) ReadHeaders() error {
+	for r.rowsCount < r.HeadersRows {
+		record, err := r.cr.Read()
+		r.rowsCount++
+		if err != nil {
+			return err
+		}
+
+		r.headerRows = append(r.headerRows, append([]string(nil), record...)) // Cloning values since r.cr.ReuseRecord could be true.
+		if r.rowsCount == r.HeadersRows {
+			r.setHeaders(r.headerRows)
+		}
+	}
+
+	return nil
+}
+
+// Marshal converts an object obtained by ReadObject to json.
+// Errors are reported as RowError of the last row read.
+func (r *Reader) Marshal(m map[string]interface{}) ([]byte, error) {
 	jsonBytes, err := doMarshal(r, m)
 	if err != nil {
-		return nil, fmt.Errorf("malformed row #%v (%s)", r.rowsCount, err)
+		return nil, &RowError{Row: r.rowsCount, Record: r.Record(), Err: err}
 	}
 
 	return jsonBytes, nil
 }
 
-func toMap(k []string, v []string) (map[string]string, error) {
+// Row returns the number of the last row read, headers rows included, starting from 1.
+func (r *Reader) Row() uint {
+	return r.rowsCount
+}
+
+// Record returns a copy of the fields of the last row read.
+func (r *Reader) Record() []string {
+	if r.record == nil {
+		return nil
+	}
+	return append([]string(nil), r.record...)
+}
+
+// convert returns the JSON value for the cell v of the column k.
+func (r *Reader) convert(k string, v string) interface{} {
+	if r.DecimalComma {
+		v = toDecimalPoint(v)
+	}
+	if r.InferTypes || contains(r.InferColumns, k) {
+		return inferValue(v, r.NullValues)
+	}
+	return v
+}
+
+// isNull reports whether v is null or one of the NullValues.
+func (r *Reader) isNull(v interface{}) bool {
+	s, ok := v.(string)
+	return v == nil || ok && contains(r.NullValues, s)
+}
+
+func toMap(k []string, v []string, conv func(k string, v string) interface{}) (map[string]interface{}, error) {
 	if len(v) != len(k) {
 		return nil, errors.New("keys and values sizes don't match")
 	}
 
-	m := make(map[string]string)
+	m := make(map[string]interface{})
 
 	for i := 0; i < len(k); i++ {
-		m[k[i]] = v[i]
+		m[k[i]] = conv(k[i], v[i])
 	}
 
 	return m, nil
 }
 
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
 func doMarshal(r *Reader, v interface{}) ([]byte, error) {
 	if r.IndentFormat {
 		return json.MarshalIndent(v, "", r.Indent)
@@ -0,0 +1,177 @@
+package csvjson_test
+
+import (
+	"encoding/csv"
+	"goex/ltser/csvjson"
+	"strings"
+	"testing"
+)
+
+func TestReadInferTypes(t *testing.T) {
+	const data = "time,station,snow,flag,note\n2020-01-01 00:00:00,B3,3.5,true,\n2020-01-01 00:15:00,B3,NaN,FALSE,12\n"
+
+	for _, c := range []struct {
+		infer   bool
+		columns []string
+		nulls   []string
+		out     []string
+	}{
+		{false, nil, nil, []string{
+			`{"flag":"true","note":"","snow":"3.5","station":"B3","time":"2020-01-01 00:00:00"}`,
+			`{"flag":"FALSE","note":"12","snow":"NaN","station":"B3","time":"2020-01-01 00:15:00"}`,
+		}},
+		{true, nil, nil, []string{
+			`{"flag":true,"note":null,"snow":3.5,"station":"B3","time":"2020-01-01 00:00:00"}`,
+			`{"flag":false,"note":12,"snow":null,"station":"B3","time":"2020-01-01 00:15:00"}`,
+		}},
+		{false, []string{"snow"}, nil, []string{
+			`{"flag":"true","note":"","snow":3.5,"station":"B3","time":"2020-01-01 00:00:00"}`,
+			`{"flag":"FALSE","note":"12","snow":null,"station":"B3","time":"2020-01-01 00:15:00"}`,
+		}},
+		{true, nil, []string{"NaN"}, []string{
+			`{"flag":true,"note":"","snow":3.5,"station":"B3","time":"2020-01-01 00:00:00"}`,
+			`{"flag":false,"note":12,"snow":null,"station":"B3","time":"2020-01-01 00:15:00"}`,
+		}},
+	} {
+		r := csvjson.NewReader(*csv.NewReader(strings.NewReader(data)))
+		r.InferTypes = c.infer
+		r.InferColumns = c.columns
+		if c.nulls != nil {
+			r.NullValues = c.nulls
+		}
+
+		for _, want := range c.out {
+			got, err := r.Read()
+			if err != nil {
+				t.Fatalf("Read() returned error %v", err)
+			}
+			if string(got) != want {
+				t.Errorf("Read() => %s != %s", got, want)
+			}
+		}
+	}
+}
+
+func TestReadInferLeadingZeros(t *testing.T) {
+	for _, c := range []struct {
+		in  string
+		out string
+	}{
+		{"007", `"007"`},
+		{"-007", `"-007"`},
+		{"00.5", `"00.5"`},
+		{"0", `0`},
+		{"-0", `0`},
+		{"0.5", `0.5`},
+		{"-0.25", `-0.25`},
+		{"0e3", `0`},
+		{"10", `10`},
+		{"100.0", `100`},
+	} {
+		r := csvjson.NewReader(*csv.NewReader(strings.NewReader("code\n" + c.in + "\n")))
+		r.InferTypes = true
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read() returned error %v", err)
+		}
+		if want := `{"code":` + c.out + `}`; string(got) != want {
+			t.Errorf("Read() of %q => %s != %s", c.in, got, want)
+		}
+	}
+}
+
+func TestReadSchema(t *testing.T) {
+	const data = "time,altitude,elevation,wind_speed,wind_speed_avg,sr_avg\n" +
+		"2020-04-30 23:45:00,1000,,2.5,NaN,3\n" +
+		"2020-05-01 00:00:00,,1000,NaN,3.1,4\n"
+
+	r := csvjson.NewReader(*csv.NewReader(strings.NewReader(data)))
+	r.Schema = &csvjson.Schema{
+		Rename:    map[string]string{"time": "timestamp"},
+		Merge:     map[string][]string{"altitude": {"altitude", "elevation"}, "wind_speed": {"wind_speed_avg", "wind_speed"}},
+		Drop:      []string{"sr_avg"},
+		Constants: map[string]interface{}{"source": "lter"},
+	}
+
+	for _, want := range []string{
+		`{"altitude":"1000","source":"lter","timestamp":"2020-04-30 23:45:00","wind_speed":"2.5"}`,
+		`{"altitude":"1000","source":"lter","timestamp":"2020-05-01 00:00:00","wind_speed":"3.1"}`,
+	} {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read() returned error %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("Read() => %s != %s", got, want)
+		}
+	}
+}
+
+func TestReadSchemaOverlappingMerge(t *testing.T) {
+	const data = "a,b,c\n1,2,3\n"
+
+	for _, c := range []struct {
+		merge map[string][]string
+		want  string
+	}{
+		// b is merged into a, then c into b.
+		{map[string][]string{"a": {"a", "b"}, "b": {"c"}}, `{"a":"1","b":"3"}`},
+		// b is merged into a, then the merged a into c.
+		{map[string][]string{"a": {"b"}, "c": {"a", "c"}}, `{"c":"2"}`},
+		{map[string][]string{"x": {"b", "a"}, "y": {"x", "c"}}, `{"y":"2"}`},
+	} {
+		for i := 0; i < 20; i++ { // Maps are iterated in random order.
+			r := csvjson.NewReader(*csv.NewReader(strings.NewReader(data)))
+			r.Schema = &csvjson.Schema{Merge: c.merge}
+			got, err := r.Read()
+			if err != nil {
+				t.Fatalf("Read() returned error %v", err)
+			}
+			if string(got) != c.want {
+				t.Errorf("Read() with merge %v => %s != %s", c.merge, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestReadHeadersModes(t *testing.T) {
+	const data = "time,air_t_avg,snow_height\n,°C,m\n2020-01-01 00:00:00,-3.2,0.4\n"
+
+	for _, c := range []struct {
+		mode     csvjson.HeadersMode
+		embed    []string
+		out      string
+		metadata map[string]map[string]string
+	}{
+		{csvjson.FirstHeaders, nil,
+			`{"air_t_avg":"-3.2","snow_height":"0.4","time":"2020-01-01 00:00:00"}`, nil},
+		{csvjson.CompositeHeaders, nil,
+			`{"air_t_avg_°C":"-3.2","snow_height_m":"0.4","time":"2020-01-01 00:00:00"}`, nil},
+		{csvjson.MetadataHeaders, []string{"units"},
+			`{"air_t_avg":"-3.2","snow_height":"0.4","time":"2020-01-01 00:00:00","units":{"air_t_avg":"°C","snow_height":"m","time":""}}`,
+			map[string]map[string]string{"units": {"time": "", "air_t_avg": "°C", "snow_height": "m"}}},
+	} {
+		r := csvjson.NewReader(*csv.NewReader(strings.NewReader(data)))
+		r.HeadersRows = 2
+		r.HeadersMode = c.mode
+		r.MetadataNames = []string{"units"}
+		r.EmbedMetadata = c.embed
+
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read() returned error %v", err)
+		}
+		if string(got) != c.out {
+			t.Errorf("Read() with mode %v => %s != %s", c.mode, got, c.out)
+		}
+		if md := r.Metadata(); len(md) != len(c.metadata) || len(md) > 0 && len(md["units"]) != len(c.metadata["units"]) {
+			t.Errorf("Metadata() with mode %v => %v != %v", c.mode, md, c.metadata)
+		}
+		for k, v := range c.metadata["units"] {
+			if r.Metadata()["units"][k] != v {
+				t.Errorf("Metadata()[units][%s] with mode %v => %q != %q", k, c.mode, r.Metadata()["units"][k], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package csvjson // import "goex/ltser/csvjson"
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// A Schema maps csv columns to json keys. It is applied to each object in the
+// following order: Rename, Merge, Drop and Constants.
+type Schema struct {
+	Rename    map[string]string      `json:"rename"`    // Column name -> new key.
+	Merge     map[string][]string    `json:"merge"`     // Key -> aliased keys, in fallback order. Keys are merged in sorted order.
+	Drop      []string               `json:"drop"`      // Keys to be removed.
+	Constants map[string]interface{} `json:"constants"` // Keys added to every object.
+}
+
+// LoadSchema reads a Schema from a json file.
+func LoadSchema(filename string) (*Schema, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := new(Schema)
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("invalid schema %s (%s)", filename, err)
+	}
+
+	return s, nil
+}
+
+// apply maps the object m according to the schema. A merged key takes the first
+// aliased value that is not null; isNull decides which values are null.
+func (s *Schema) apply(m map[string]interface{}, isNull func(v interface{}) bool) {
+	s.applyKeys(m, isNull)
+
+	for k, v := range s.Constants {
+		m[k] = v
+	}
+}
+
+// applyKeys applies the Rename, Merge and Drop steps of the schema.
+func (s *Schema) applyKeys(m map[string]interface{}, isNull func(v interface{}) bool) {
+	renamed := make(map[string]interface{}, len(s.Rename)) // Renames are simultaneous, so a->b, b->a swaps keys.
+	for from, to := range s.Rename {
+		if v, ok := m[from]; ok {
+			delete(m, from)
+			renamed[to] = v
+		}
+	}
+	for k, v := range renamed {
+		m[k] = v
+	}
+
+	targets := make([]string, 0, len(s.Merge))
+	for to := range s.Merge {
+		targets = append(targets, to)
+	}
+	sort.Strings(targets) // A key may be an alias of another one: the result must not depend on the map order.
+	for _, to := range targets {
+		aliases := s.Merge[to]
+		var (
+			value interface{}
+			found bool
+		)
+		for _, a := range aliases {
+			v, ok := m[a]
+			if !ok {
+				continue
+			}
+			delete(m, a)
+			if !found || (isNull(value) && !isNull(v)) {
+				value, found = v, true
+			}
+		}
+		if found {
+			m[to] = value
+		}
+	}
+
+	for _, k := range s.Drop {
+		delete(m, k)
+	}
+}
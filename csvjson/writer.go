@@ -0,0 +1,245 @@
+package csvjson // import "goex/ltser/csvjson"
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ColumnsOrder defines the order of the columns not listed in Writer.Columns.
+type ColumnsOrder byte
+
+// Available columns orders.
+const (
+	FirstSeenOrder ColumnsOrder = iota // Keys in the order of the first object containing them (sorted within an object).
+	SortedOrder                        // Keys in lexicographic order.
+)
+
+const (
+	defKeySeparator = "."
+	defNullValue    = ""
+	defColumnsOrder = FirstSeenOrder
+)
+
+// A Writer convert json objects into records written by a csv.Writer.
+//
+// Nested objects are flattened by joining keys with KeySeparator; arrays are written as json text.
+// The header row lists Columns first, then the other keys found in the objects, in ColumnsOrder.
+// Since the header depends on the union of the keys, objects are buffered until Flush is called,
+// unless OnlyColumns is true: then keys not listed in Columns are dropped and objects are written
+// immediately. Once the header is written the columns are fixed and unknown keys are dropped.
+// Metadata rows (e.g. units, see Reader.Metadata) are written after the header row.
+type Writer struct {
+	Columns      []string
+	ColumnsOrder ColumnsOrder
+	OnlyColumns  bool
+	KeySeparator string
+	NullValue    string
+	Metadata     []map[string]string
+	cw           *csv.Writer
+	header       []string
+	keys         map[string]bool
+	buffer       []map[string]string
+}
+
+// NewWriter returns a new Writer that writes to w.
+func NewWriter(w csv.Writer) *Writer {
+	csvconvWriter := new(Writer)
+	csvconvWriter.ColumnsOrder = defColumnsOrder
+	csvconvWriter.KeySeparator = defKeySeparator
+	csvconvWriter.NullValue = defNullValue
+	csvconvWriter.cw = &w
+	csvconvWriter.keys = make(map[string]bool)
+
+	return csvconvWriter
+}
+
+// Write converts one json object into a csv record.
+func (w *Writer) Write(b []byte) error {
+	var v map[string]interface{}
+	if err := unmarshal(b, &v); err != nil {
+		return fmt.Errorf("malformed object (%s)", err)
+	}
+	return w.writeObject(v)
+}
+
+// WriteAll converts a stream of json objects (one after the other, as in NDJSON,
+// or wrapped in a json array) into csv records, then flushes.
+func (w *Writer) WriteAll(r io.Reader) error {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+
+	isArray, err := startsWith(br, '[')
+	if err != nil {
+		return err
+	}
+	if isArray {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+
+	for n := 1; !isArray || dec.More(); n++ {
+		var v map[string]interface{}
+		err := dec.Decode(&v)
+		if err == io.EOF && !isArray {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("malformed object #%v (%s)", n, err)
+		}
+		if err := w.writeObject(v); err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// Flush writes the header and any buffered object to the underlying csv.Writer.
+func (w *Writer) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	for _, m := range w.buffer {
+		if err := w.cw.Write(w.record(m)); err != nil {
+			return err
+		}
+	}
+	w.buffer = nil
+
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+func (w *Writer) writeObject(v map[string]interface{}) error {
+	m := make(map[string]string, len(v))
+	w.flatten("", v, m)
+
+	if w.header == nil && !w.OnlyColumns {
+		w.buffer = append(w.buffer, m)
+		return nil
+	}
+
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.cw.Write(w.record(m))
+}
+
+// writeHeader writes header and metadata rows, if not written yet.
+func (w *Writer) writeHeader() error {
+	if w.header != nil {
+		return nil
+	}
+
+	w.header = append([]string{}, w.Columns...)
+	if !w.OnlyColumns {
+		for _, k := range w.Columns {
+			delete(w.keys, k)
+		}
+		extra := w.orderedKeys()
+		w.header = append(w.header, extra...)
+	}
+
+	if err := w.cw.Write(w.header); err != nil {
+		return err
+	}
+	for _, md := range w.Metadata {
+		if err := w.cw.Write(w.record(md)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderedKeys returns the keys found in the buffered objects, in ColumnsOrder.
+func (w *Writer) orderedKeys() []string {
+	var keys []string
+	for _, m := range w.buffer {
+		for _, k := range sortedKeys(m) { // Keys of a single object have no order: sorting them keeps the result stable.
+			if w.keys[k] {
+				keys = append(keys, k)
+				delete(w.keys, k)
+			}
+		}
+	}
+	if w.ColumnsOrder == SortedOrder {
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+func (w *Writer) record(m map[string]string) []string {
+	record := make([]string, len(w.header))
+	for i, k := range w.header {
+		record[i] = m[k]
+	}
+	return record
+}
+
+// flatten converts the json object v into m, prefixing keys of nested objects.
+func (w *Writer) flatten(prefix string, v map[string]interface{}, m map[string]string) {
+	for k, val := range v {
+		key := prefix + k
+		switch val := val.(type) {
+		case map[string]interface{}:
+			w.flatten(key+w.KeySeparator, val, m)
+			continue
+		case nil:
+			m[key] = w.NullValue
+		case string:
+			m[key] = val
+		case json.Number:
+			m[key] = val.String()
+		case bool:
+			m[key] = strconv.FormatBool(val)
+		default:
+			b, _ := json.Marshal(val) // Values obtained by unmarshaling json can always be marshaled.
+			m[key] = string(b)
+		}
+		if w.header == nil && !w.OnlyColumns {
+			w.keys[key] = true
+		}
+	}
+}
+
+func unmarshal(b []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// startsWith reports whether the first non space byte read from br is c, without consuming it.
+func startsWith(br *bufio.Reader, c byte) (bool, error) {
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+		default:
+			return b[0] == c, nil
+		}
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
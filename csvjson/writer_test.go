@@ -0,0 +1,51 @@
+package csvjson_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"goex/ltser/csvjson"
+	"strings"
+	"testing"
+)
+
+func TestWriteAll(t *testing.T) {
+	const ndjson = `{"time":"2020-01-01 00:00:00","station":"B3","snow_height":0.4,"units":{"snow_height":"m"}}
+{"station":"B3","time":"2020-01-01 00:15:00","air_t_avg":-3.25,"valid":true,"snow_height":null}
+`
+	const array = `[{"time":"2020-01-01 00:00:00","station":"B3","snow_height":0.4,"units":{"snow_height":"m"}},
+{"station":"B3","time":"2020-01-01 00:15:00","air_t_avg":-3.25,"valid":true,"snow_height":null}]`
+
+	for _, c := range []struct {
+		in      string
+		columns []string
+		order   csvjson.ColumnsOrder
+		only    bool
+		out     string
+	}{
+		{ndjson, nil, csvjson.FirstSeenOrder, false,
+			"snow_height,station,time,units.snow_height,air_t_avg,valid\n" +
+				"0.4,B3,2020-01-01 00:00:00,m,,\n" +
+				",B3,2020-01-01 00:15:00,,-3.25,true\n"},
+		{array, []string{"time", "station"}, csvjson.SortedOrder, false,
+			"time,station,air_t_avg,snow_height,units.snow_height,valid\n" +
+				"2020-01-01 00:00:00,B3,,0.4,m,\n" +
+				"2020-01-01 00:15:00,B3,-3.25,,,true\n"},
+		{ndjson, []string{"time", "air_t_avg"}, csvjson.FirstSeenOrder, true,
+			"time,air_t_avg\n" +
+				"2020-01-01 00:00:00,\n" +
+				"2020-01-01 00:15:00,-3.25\n"},
+	} {
+		var b bytes.Buffer
+		w := csvjson.NewWriter(*csv.NewWriter(&b))
+		w.Columns = c.columns
+		w.ColumnsOrder = c.order
+		w.OnlyColumns = c.only
+
+		if err := w.WriteAll(strings.NewReader(c.in)); err != nil {
+			t.Fatalf("WriteAll() returned error %v", err)
+		}
+		if b.String() != c.out {
+			t.Errorf("WriteAll() =>\n%s!=\n%s", b.String(), c.out)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package extensions // import "goex/ltser/extensions"
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Suffix of the keys and environment variables whose value is read from a file (e.g. TOKEN_FILE).
+const fileSuffix = "_file"
+
+// An Alias is another name of a flag (e.g. "token" for flag "t"), accepted in configuration
+// files and environment variables.
+type Alias struct {
+	Name string
+	Flag string
+}
+
+// Configure parses the command line arguments into the flags of fs, after setting them from
+// a configuration file and from environment variables. Precedence, from the highest, is:
+// command line, environment variables, configuration file, default values.
+//
+// The configuration file is given by the -config flag, that Configure defines, or by the
+// <prefix>_CONFIG environment variable. It is a JSON object if its extension is .json, a
+// TOML document of key/value pairs otherwise (see ParseTOML). Its keys are flag names or
+// aliases (e.g. "token" for flag "t"). Environment variables are named <prefix>_<KEY>, with
+// the key upper case and "-" replaced by "_" (e.g. INGESTOR_TOKEN).
+//
+// A key or environment variable with suffix "_file" sets the flag to the content of the
+// file it names, without trailing new line: secrets need not be passed on the command line.
+//
+// If several environment variables name the same flag, the first one set wins, in order:
+// the flag name, then its aliases in the order of aliases; for each, the value before "_file".
+func Configure(fs *flag.FlagSet, prefix string, aliases []Alias, args []string) error {
+	configFile := fs.String("config", "", "Configuration file (JSON, or TOML). Values can also be set by "+prefix+"_<FLAG> environment variables.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	if *configFile == "" {
+		*configFile = os.Getenv(envName(prefix, "config"))
+	}
+	if *configFile != "" {
+		values, err := loadConfig(*configFile)
+		if err != nil {
+			return fmt.Errorf("%s: %v", *configFile, err)
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := set(fs, aliases, onCommandLine, k, values[k]); err != nil {
+				return fmt.Errorf("%s: %v", *configFile, err)
+			}
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" {
+			return
+		}
+		names := []string{f.Name}
+		for _, a := range aliases {
+			if a.Flag == f.Name {
+				names = append(names, a.Name)
+			}
+		}
+		for _, n := range names {
+			for _, k := range []string{n, n + fileSuffix} {
+				if v, ok := os.LookupEnv(envName(prefix, k)); ok {
+					if e := set(fs, aliases, onCommandLine, k, v); e != nil {
+						err = fmt.Errorf("%s: %v", envName(prefix, k), e)
+					}
+					return
+				}
+			}
+		}
+	})
+
+	return err
+}
+
+func envName(prefix, key string) string {
+	return strings.ToUpper(prefix + "_" + strings.Replace(key, "-", "_", -1))
+}
+
+// set sets the flag of a key, unless it has been set on the command line.
+func set(fs *flag.FlagSet, aliases []Alias, onCommandLine map[string]bool, key, value string) error {
+	if strings.HasSuffix(key, fileSuffix) && lookup(fs, aliases, key) == nil {
+		key = strings.TrimSuffix(key, fileSuffix)
+		b, err := ioutil.ReadFile(value)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimRight(string(b), "\r\n")
+	}
+
+	f := lookup(fs, aliases, key)
+	if f == nil {
+		return fmt.Errorf("unknown key %q", key)
+	}
+	if onCommandLine[f.Name] {
+		return nil
+	}
+	if err := fs.Set(f.Name, value); err != nil {
+		return fmt.Errorf("invalid value for %q (%v)", key, err)
+	}
+	return nil
+}
+
+func lookup(fs *flag.FlagSet, aliases []Alias, key string) *flag.Flag {
+	for _, a := range aliases {
+		if a.Name == key {
+			return fs.Lookup(a.Flag)
+		}
+	}
+	return fs.Lookup(key)
+}
+
+// loadConfig reads a configuration file into flag values. Arrays are joined with commas.
+func loadConfig(filename string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if strings.ToLower(filepath.Ext(filename)) == ".json" {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&raw)
+	} else {
+		raw, err = ParseTOML(string(b))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if a, ok := v.([]interface{}); ok {
+			s := make([]string, len(a))
+			for i, e := range a {
+				s[i] = fmt.Sprint(e)
+			}
+			values[k] = strings.Join(s, ",")
+		} else {
+			values[k] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
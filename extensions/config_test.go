@@ -0,0 +1,138 @@
+package extensions_test
+
+import (
+	"flag"
+	ext "goex/ltser/extensions"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	var tests = []struct {
+		doc  string
+		want map[string]interface{}
+		ok   bool
+	}{
+		{"# comment\nurl = \"http://localhost:8000\" # trailing\n\n'quoted key' = 'C:\\data'\n",
+			map[string]interface{}{"url": "http://localhost:8000", "quoted key": `C:\data`}, true},
+		{"c = 4\nratio = 0.5\nsize = 1_000\ninfer = true\nnulls = [\"\", \"NaN\", \"a,b\"]",
+			map[string]interface{}{"c": int64(4), "ratio": 0.5, "size": int64(1000), "infer": true,
+				"nulls": []interface{}{"", "NaN", "a,b"}}, true},
+		{"s = \"tab\\t#not a comment\"", map[string]interface{}{"s": "tab\t#not a comment"}, true},
+		{"d = 0\nn = -0\nh = 0xff_FF\no = 0o17\nb = 0b101\nf = -0.25\ne = 1e3",
+			map[string]interface{}{"d": int64(0), "n": int64(0), "h": int64(0xffff), "o": int64(15), "b": int64(5),
+				"f": -0.25, "e": 1000.0}, true},
+		{"key = 010", nil, false},
+		{"key = -010", nil, false},
+		{"key = 01.5", nil, false},
+		{"key = +0x10", nil, false},
+		{"key = 0X10", nil, false},
+		{"key = 0o8", nil, false},
+		{"key = 0x1p3", nil, false},
+		{"[table]\nkey = 1", nil, false},
+		{"key 1", nil, false},
+		{"key = ", nil, false},
+		{"key = value", nil, false},
+		{"key = 1\nkey = 2", nil, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ext.ParseTOML(tt.doc)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseTOML(%q) error = %v, want ok %v", tt.doc, err, tt.ok)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTOML(%q) = %v, want %v", tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := filepath.Join(dir, "test.toml")
+	secret := filepath.Join(dir, "token")
+	ioutil.WriteFile(config, []byte("url = \"http://config\"\norg = \"config\"\nbucket = \"config\"\nlist = [\"a\", \"b\"]\n"), 0600)
+	ioutil.WriteFile(secret, []byte("s3cr3t\n"), 0600)
+	os.Setenv("TEST_ORG", "env")
+	os.Setenv("TEST_B", "env")
+	os.Setenv("TEST_TOKEN_FILE", secret)
+	defer os.Unsetenv("TEST_ORG")
+	defer os.Unsetenv("TEST_B")
+	defer os.Unsetenv("TEST_TOKEN_FILE")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	url := fs.String("u", "default", "")
+	org := fs.String("o", "default", "")
+	bucket := fs.String("b", "default", "")
+	token := fs.String("t", "", "")
+	port := fs.String("p", "default", "")
+	var list ext.StringListFlag
+	fs.Var(&list, "list", "")
+	aliases := []ext.Alias{{Name: "url", Flag: "u"}, {Name: "org", Flag: "o"}, {Name: "bucket", Flag: "b"}, {Name: "token", Flag: "t"}, {Name: "port", Flag: "p"}}
+
+	if err := ext.Configure(fs, "test", aliases, []string{"-config", config, "-b", "flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{*url, *org, *bucket, *token, *port}
+	want := []string{"http://config", "env", "flag", "s3cr3t", "default"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Configure() flags = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(list.Value(), []string{"a", "b"}) {
+		t.Errorf("Configure() list = %q, want %q", list.Value(), []string{"a", "b"})
+	}
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("u", "", "")
+	ioutil.WriteFile(config, []byte("unknown = 1\n"), 0600)
+	if err := ext.Configure(fs, "test", nil, []string{"-config", config}); err == nil {
+		t.Errorf("Configure() with unknown key: no error")
+	}
+}
+
+func TestConfigureEnvPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "token")
+	ioutil.WriteFile(secret, []byte("file\n"), 0600)
+
+	aliases := []ext.Alias{{Name: "token", Flag: "t"}, {Name: "api-token", Flag: "t"}}
+	for _, c := range []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"TEST_API_TOKEN": "api-token"}, "api-token"},
+		{map[string]string{"TEST_TOKEN": "token", "TEST_API_TOKEN": "api-token"}, "token"},
+		{map[string]string{"TEST_T": "t", "TEST_TOKEN": "token", "TEST_API_TOKEN": "api-token"}, "t"},
+		{map[string]string{"TEST_TOKEN_FILE": secret, "TEST_API_TOKEN": "api-token"}, "file"},
+		{map[string]string{"TEST_TOKEN": "token", "TEST_TOKEN_FILE": secret}, "token"},
+	} {
+		for k, v := range c.env {
+			os.Setenv(k, v)
+		}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		token := fs.String("t", "", "")
+		if err := ext.Configure(fs, "test", aliases, nil); err != nil {
+			t.Fatal(err)
+		}
+		if *token != c.want {
+			t.Errorf("Configure() with %v: token = %q, want %q", c.env, *token, c.want)
+		}
+		for k := range c.env {
+			os.Unsetenv(k)
+		}
+	}
+}
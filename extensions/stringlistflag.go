@@ -0,0 +1,27 @@
+package extensions // import "goex/ltser/extensions"
+
+import (
+	"strings"
+)
+
+// StringListFlag implements flag.Value interface for comma separated lists of strings.
+// Empty elements are kept, so ",NaN" is the list of the empty string and "NaN".
+type StringListFlag struct {
+	values []string
+}
+
+// String method of flag.Value interface.
+func (n *StringListFlag) String() string {
+	return strings.Join(n.values, ",")
+}
+
+// Set method of flag.Value interface.
+func (n *StringListFlag) Set(value string) error {
+	n.values = strings.Split(value, ",")
+	return nil
+}
+
+// Value returns the embedded list, nil if the flag was never set.
+func (n *StringListFlag) Value() []string {
+	return n.values
+}
@@ -0,0 +1,180 @@
+package extensions // import "goex/ltser/extensions"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseTOML parses the subset of TOML used by configuration files: comments and key/value
+// pairs, with bare or quoted keys and string, integer, float, boolean or single line array
+// values. Tables are not supported. Integers are returned as int64, floats as float64 and
+// arrays as []interface{}.
+func ParseTOML(doc string) (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+
+	for i, line := range strings.Split(doc, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %v: tables are not supported", i+1)
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("line %v: missing \"=\"", i+1)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if k, err := parseTOMLString(key); err == nil {
+			key = k
+		}
+		if key == "" {
+			return nil, fmt.Errorf("line %v: missing key", i+1)
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("line %v: duplicated key %q", i+1, key)
+		}
+
+		v, err := parseTOMLValue(strings.TrimSpace(line[eq+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", i+1, err)
+		}
+		m[key] = v
+	}
+
+	return m, nil
+}
+
+// stripComment removes a comment, if any, outside quoted strings.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseTOMLValue(s string) (interface{}, error) {
+	switch {
+	case s == "":
+		return nil, fmt.Errorf("missing value")
+	case s == "true":
+		return true, nil
+	case s == "false":
+		return false, nil
+	case s[0] == '"' || s[0] == '\'':
+		return parseTOMLString(s)
+	case s[0] == '[':
+		return parseTOMLArray(s)
+	}
+
+	if v, ok := parseTOMLNumber(strings.Replace(s, "_", "", -1)); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid value %s", s)
+}
+
+// parseTOMLNumber parses an integer, decimal or, with prefix 0x, 0o or 0b (and no sign),
+// hexadecimal, octal or binary, or a float. Decimal numbers cannot have leading zeros.
+func parseTOMLNumber(n string) (interface{}, bool) {
+	if len(n) > 2 && n[0] == '0' {
+		base := 0
+		switch n[1] {
+		case 'x':
+			base = 16
+		case 'o':
+			base = 8
+		case 'b':
+			base = 2
+		}
+		if base != 0 {
+			u, err := strconv.ParseUint(n[2:], base, 63)
+			return int64(u), err == nil
+		}
+	}
+
+	digits := n
+	if strings.HasPrefix(n, "-") || strings.HasPrefix(n, "+") {
+		digits = n[1:]
+	}
+	if len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' {
+		return nil, false
+	}
+
+	if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+		return i, true
+	}
+	if strings.ContainsAny(n, "xXpP") { // Hexadecimal floats are not TOML.
+		return nil, false
+	}
+	if f, err := strconv.ParseFloat(n, 64); err == nil {
+		return f, true
+	}
+	return nil, false
+}
+
+// parseTOMLString parses a basic ("...", with escapes) or literal ('...') string.
+func parseTOMLString(s string) (string, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' && strings.IndexByte(s[1:len(s)-1], '\'') < 0 {
+		return s[1 : len(s)-1], nil
+	}
+	if len(s) >= 2 && s[0] == '"' {
+		return strconv.Unquote(s)
+	}
+	return "", fmt.Errorf("invalid string %s", s)
+}
+
+func parseTOMLArray(s string) ([]interface{}, error) {
+	if s[len(s)-1] != ']' {
+		return nil, fmt.Errorf("invalid array %s", s)
+	}
+
+	a := []interface{}{}
+	for _, e := range splitTOMLArray(s[1 : len(s)-1]) {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue // Trailing comma.
+		}
+		v, err := parseTOMLValue(e)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.([]interface{}); ok {
+			return nil, fmt.Errorf("nested arrays are not supported")
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+// splitTOMLArray splits the elements of an array at the commas outside quoted strings.
+func splitTOMLArray(s string) []string {
+	var elems []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == ',':
+			elems = append(elems, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(elems, s[start:])
+}
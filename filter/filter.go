@@ -0,0 +1,208 @@
+// Package filter provide a small expression language to select json objects,
+// e.g. rows read by a *csvjson.Reader.
+//
+// An expression compares object fields and literals with the operators
+// ==, !=, <, <=, > and >=, combined with &&, || and ! and grouped by parentheses:
+//
+//	station == "B3" && time >= "2019-01-01" && snow_height != null
+//
+// Fields are identifiers (letters, digits, '_' and '.') or names quoted by backticks.
+// Literals are strings in double quotes, numbers, true, false and null.
+// Values are compared as numbers if both can be parsed as numbers, as timestamps if
+// both can be parsed as timestamps (see TimeLayouts), as strings otherwise.
+// Null, missing fields and empty strings are equal to each other and are neither
+// less nor greater than any value.
+package filter // import "goex/ltser/filter"
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeLayouts are the formats tried when parsing timestamps.
+var TimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+// An Expr is a parsed filter expression.
+type Expr struct {
+	source string
+	root   node
+}
+
+// Parse parses a filter expression.
+func Parse(s string) (*Expr, error) {
+	p := parser{lexer: lexer{input: s}}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != eofToken {
+		return nil, p.unexpected()
+	}
+
+	return &Expr{source: s, root: root}, nil
+}
+
+// Match reports whether the object satisfies the expression.
+func (e *Expr) Match(obj map[string]interface{}) bool {
+	return e.root.eval(obj)
+}
+
+func (e *Expr) String() string {
+	return e.source
+}
+
+type node interface {
+	eval(obj map[string]interface{}) bool
+}
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(obj map[string]interface{}) bool {
+	return n.left.eval(obj) || n.right.eval(obj)
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(obj map[string]interface{}) bool {
+	return n.left.eval(obj) && n.right.eval(obj)
+}
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(obj map[string]interface{}) bool {
+	return !n.operand.eval(obj)
+}
+
+type compareNode struct {
+	op          string
+	left, right operand
+}
+
+func (n compareNode) eval(obj map[string]interface{}) bool {
+	c, ok := compare(n.left.value(obj), n.right.value(obj))
+	switch n.op {
+	case "==":
+		return ok && c == 0
+	case "!=":
+		return !ok || c != 0
+	case "<":
+		return ok && c < 0
+	case "<=":
+		return ok && c <= 0
+	case ">":
+		return ok && c > 0
+	case ">=":
+		return ok && c >= 0
+	}
+	return false
+}
+
+// An operand is either a field or a literal.
+type operand struct {
+	field   string
+	literal interface{}
+	isField bool
+}
+
+func (o operand) value(obj map[string]interface{}) interface{} {
+	if o.isField {
+		return obj[o.field]
+	}
+	return o.literal
+}
+
+// compare returns -1, 0 or +1 depending on whether a is less than, equal to or greater than b.
+// The result is not ok if the values cannot be compared.
+func compare(a, b interface{}) (int, bool) {
+	as, aNull := toString(a)
+	bs, bNull := toString(b)
+	if aNull || bNull {
+		if aNull && bNull {
+			return 0, true
+		}
+		return 0, false
+	}
+
+	if af, ok := toFloat(a, as); ok {
+		if bf, ok := toFloat(b, bs); ok {
+			return compareFloats(af, bf)
+		}
+	}
+
+	if at, ok := ParseTime(as); ok {
+		if bt, ok := ParseTime(bs); ok {
+			switch {
+			case at.Before(bt):
+				return -1, true
+			case at.After(bt):
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	return strings.Compare(as, bs), true
+}
+
+func compareFloats(a, b float64) (int, bool) {
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		return 0, false
+	case a < b:
+		return -1, true
+	case a > b:
+		return 1, true
+	}
+	return 0, true
+}
+
+// toString returns the text of a value, and whether the value is null or empty.
+func toString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, v == ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), false
+	case int64:
+		return strconv.FormatInt(v, 10), false
+	}
+	return fmt.Sprint(v), false
+}
+
+func toFloat(v interface{}, s string) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case bool:
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return f, err == nil
+}
+
+// ParseTime parses a timestamp in one of the TimeLayouts.
+func ParseTime(s string) (time.Time, bool) {
+	for _, layout := range TimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,62 @@
+package filter_test
+
+import (
+	"goex/ltser/filter"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	row := map[string]interface{}{
+		"time":        "2019-03-04 12:00:00",
+		"station":     "B3",
+		"snow_height": "",
+		"air_t_avg":   "-3.5",
+		"altitude":    int64(1000),
+		"wind":        nil,
+		"valid":       true,
+	}
+
+	for _, c := range []struct {
+		expr string
+		out  bool
+	}{
+		{`station == "B3"`, true},
+		{`station == "B3" && time >= "2019-01-01"`, true},
+		{`station == "B3" && time >= "2019-03-04 12:00:01"`, false},
+		{`time < "2019-03-05" && time > "2019-03-04T11:59:59"`, true},
+		{`air_t_avg < -3`, true},
+		{`air_t_avg < "-10"`, false},
+		{`altitude >= 1000 && altitude < 1e4`, true},
+		{`snow_height != ""`, false},
+		{`snow_height == null && wind == "" && missing == null`, true},
+		{`snow_height < 1 || snow_height >= 1`, false},
+		{`!(station == "B1" || station == "B2")`, true},
+		{`valid == true && valid != false`, true},
+		{"`air_t_avg` != \"NaN\"", true},
+	} {
+		e, err := filter.Parse(c.expr)
+		if err != nil {
+			t.Fatalf("Parse(%s) returned error %v", c.expr, err)
+		}
+		if got := e.Match(row); got != c.out {
+			t.Errorf("Match(%s) => %v != %v", c.expr, got, c.out)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`station`,
+		`station == `,
+		`station == "B3`,
+		`(station == "B3"`,
+		`station == "B3" &&`,
+		`station = "B3"`,
+		`station == "B3" station`,
+	} {
+		if _, err := filter.Parse(expr); err == nil {
+			t.Errorf("Parse(%s) should have returned an error", expr)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package filter // import "goex/ltser/filter"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind byte
+
+const (
+	eofToken tokenKind = iota
+	identToken
+	stringToken
+	numberToken
+	operatorToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+// scan returns the next token of the input.
+func (l *lexer) scan() (token, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: eofToken, pos: start}, nil
+	}
+
+	c := l.input[l.pos]
+	switch {
+	case c == '"':
+		for l.pos++; l.pos < len(l.input); l.pos++ {
+			switch l.input[l.pos] {
+			case '\\':
+				l.pos++
+			case '"':
+				l.pos++
+				text, err := strconv.Unquote(l.input[start:l.pos])
+				if err != nil {
+					return token{}, fmt.Errorf("invalid string at position %v (%s)", start, err)
+				}
+				return token{kind: stringToken, text: text, pos: start}, nil
+			}
+		}
+		return token{}, fmt.Errorf("unterminated string at position %v", start)
+	case c == '`':
+		end := strings.IndexByte(l.input[start+1:], '`')
+		if end < 0 {
+			return token{}, fmt.Errorf("unterminated field name at position %v", start)
+		}
+		l.pos = start + end + 2
+		return token{kind: identToken, text: l.input[start+1 : start+1+end], pos: start}, nil
+	case c == '-' || c == '+' || c == '.' || isDigit(c):
+		for l.pos++; l.pos < len(l.input) && (isDigit(l.input[l.pos]) || strings.IndexByte(".eE+-", l.input[l.pos]) >= 0); l.pos++ {
+		}
+		return token{kind: numberToken, text: l.input[start:l.pos], pos: start}, nil
+	case isIdentStart(c):
+		for l.pos++; l.pos < len(l.input) && (isIdentStart(l.input[l.pos]) || isDigit(l.input[l.pos]) || l.input[l.pos] == '.'); l.pos++ {
+		}
+		return token{kind: identToken, text: l.input[start:l.pos], pos: start}, nil
+	}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"} {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: operatorToken, text: op, pos: start}, nil
+		}
+	}
+
+	return token{}, fmt.Errorf("unexpected character %q at position %v", c, start)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// A parser builds the expression tree by recursive descent.
+type parser struct {
+	lexer
+	tok token
+}
+
+func (p *parser) next() (err error) {
+	p.tok, err = p.scan()
+	return err
+}
+
+func (p *parser) is(op string) bool {
+	return p.tok.kind == operatorToken && p.tok.text == op
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == eofToken {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at position %v", p.tok.text, p.tok.pos)
+}
+
+// parseOr parses: and { "||" and }.
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.is("||") {
+		var right node
+		if err = p.next(); err != nil {
+			break
+		}
+		right, err = p.parseAnd()
+		left = orNode{left, right}
+	}
+	return left, err
+}
+
+// parseAnd parses: unary { "&&" unary }.
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.is("&&") {
+		var right node
+		if err = p.next(); err != nil {
+			break
+		}
+		right, err = p.parseUnary()
+		left = andNode{left, right}
+	}
+	return left, err
+}
+
+// parseUnary parses: "!" unary | "(" or ")" | operand operator operand.
+func (p *parser) parseUnary() (node, error) {
+	switch {
+	case p.is("!"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		n, err := p.parseUnary()
+		return notNode{n}, err
+	case p.is("("):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			return nil, p.unexpected()
+		}
+		return n, p.next()
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != operatorToken || !isComparison(p.tok.text) {
+		return nil, p.unexpected()
+	}
+	op := p.tok.text
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	return compareNode{op: op, left: left, right: right}, nil
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	var o operand
+	switch p.tok.kind {
+	case stringToken:
+		o.literal = p.tok.text
+	case numberToken:
+		f, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return o, fmt.Errorf("invalid number %q at position %v", p.tok.text, p.tok.pos)
+		}
+		o.literal = f
+	case identToken:
+		switch p.tok.text {
+		case "true":
+			o.literal = true
+		case "false":
+			o.literal = false
+		case "null":
+			o.literal = nil
+		default:
+			o.field, o.isField = p.tok.text, true
+		}
+	default:
+		return o, p.unexpected()
+	}
+
+	return o, p.next()
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
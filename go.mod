@@ -8,4 +8,5 @@ require (
 	github.com/avast/retry-go v2.6.0+incompatible
 	github.com/gitdruid/adf v0.0.0-20200424103703-38961ff26a4b
 	github.com/influxdata/influxdb-client-go v1.1.0
+	golang.org/x/text v0.3.2
 )
@@ -44,6 +44,12 @@ const (
 	snowFieldName           = "height"
 )
 
+// WrittenFields are the models.RawData json fields used by Write: the other fields are ignored.
+var WrittenFields = []string{
+	"time", "station", "altitude", "elevation", "latitude", "longitude",
+	"air_t_avg", "wind_speed", "wind_speed_avg", "wind_speed_max", "air_rh_avg", "precip_rt_nrt_tot", "snow_height",
+}
+
 // Save parse raw sensors' data and store valid data into separate measurements.
 func (s *Store) Write(sd models.RawData) error {
 
@@ -53,7 +59,7 @@ func (s *Store) Write(sd models.RawData) error {
 	writeAPI := client.WriteApiBlocking(s.org, s.bucket)
 
 	// Obtaining Time.
-	t, err := time.Parse("2006-01-02 15:04:05 -0700", sd.Time+" +0100") // Measurement time is (UTC +1).
+	t, err := models.ParseTime(sd.Time) // Measurement time is (UTC +1).
 	if err != nil {
 		return err
 	}
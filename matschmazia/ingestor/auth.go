@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	httpsender "goex/ltser/sender/http"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// authenticate wraps a handler with the verification of the credentials configured by the auth flags:
+// bearer token or basic auth, and HMAC signature. Requests without valid credentials get a 401.
+func authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := checkCredentials(r); err != nil {
+			log.Printf("An error occurred: %q.\n", err)
+			switch {
+			case authToken != "":
+				w.Header().Set("WWW-Authenticate", `Bearer realm="ingestor"`)
+			case authUser != "":
+				w.Header().Set("WWW-Authenticate", `Basic realm="ingestor"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// checkCredentials verifies the credentials of a request. With HMAC, the body is read
+// and replaced by a copy, to be read again by the handler.
+func checkCredentials(r *http.Request) error {
+	switch {
+	case authToken != "":
+		h := r.Header.Get("Authorization")
+		if !strings.HasPrefix(h, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(h[len("Bearer "):]), []byte(authToken)) != 1 {
+			return errors.New("invalid bearer token")
+		}
+	case authUser != "":
+		user, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(authUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(authPassword)) != 1 {
+			return errors.New("invalid basic auth credentials")
+		}
+	}
+
+	if hmacKey != "" {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return err
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if err := httpsender.VerifySignature(r.Header, body, []byte(hmacKey), hmacMaxSkew); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
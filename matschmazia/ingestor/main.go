@@ -2,17 +2,23 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	ext "goex/ltser/extensions"
 	"goex/ltser/matschmazia/db"
 	"goex/ltser/matschmazia/db/influxdb2"
 	"goex/ltser/matschmazia/models"
+	"goex/ltser/sender"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"time"
 )
 
 var (
@@ -22,10 +28,26 @@ var (
 	token  string
 	host   string
 	port   string
+
+	authToken    string
+	authUser     string
+	authPassword string
+	hmacKey      string
+	hmacMaxSkew  time.Duration
 )
 
 var dataStore db.Writer
 
+// Long names of the flags, accepted in configuration files and environment variables (e.g. INGESTOR_TOKEN).
+var flagAliases = []ext.Alias{
+	{Name: "url", Flag: "u"},
+	{Name: "org", Flag: "o"},
+	{Name: "bucket", Flag: "b"},
+	{Name: "token", Flag: "t"},
+	{Name: "host", Flag: "h"},
+	{Name: "port", Flag: "p"},
+}
+
 func init() {
 	flag.StringVar(&url, "u", "", "Target url of InfluxDB instance.")
 	flag.StringVar(&org, "o", "", "Target organization.")
@@ -33,16 +55,28 @@ func init() {
 	flag.StringVar(&token, "t", "", "Auth token.")
 	flag.StringVar(&host, "h", "localhost", "Service ip.")
 	flag.StringVar(&port, "p", "8000", "Service port.")
+	flag.StringVar(&authToken, "auth-token", "", "Bearer token required to the clients. Better given as INGESTOR_AUTH_TOKEN or INGESTOR_AUTH_TOKEN_FILE.")
+	flag.StringVar(&authUser, "auth-user", "", "User required to the clients with basic auth (see -auth-password).")
+	flag.StringVar(&authPassword, "auth-password", "", "Password required to the clients with basic auth. Better given as INGESTOR_AUTH_PASSWORD or INGESTOR_AUTH_PASSWORD_FILE.")
+	flag.StringVar(&hmacKey, "hmac-key", "", "Shared key verifying the HMAC-SHA256 signature of each request. Better given as INGESTOR_HMAC_KEY or INGESTOR_HMAC_KEY_FILE.")
+	flag.DurationVar(&hmacMaxSkew, "hmac-max-skew", 5*time.Minute, "Max difference between the timestamp of a signed request and now. 0 means no limit.")
 }
 
 func main() {
-	flag.Parse()
+	if err := ext.Configure(flag.CommandLine, "INGESTOR", flagAliases, os.Args[1:]); err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		os.Exit(-1)
+	}
 
 	if url == "" || org == "" || bucket == "" || token == "" || host == "" || port == "" {
 		fmt.Fprintln(flag.CommandLine.Output(), "Missing or empty parameter.")
 		flag.Usage()
 		os.Exit(-1)
 	}
+	if authToken != "" && authUser != "" {
+		fmt.Fprintln(flag.CommandLine.Output(), "-auth-token and -auth-user are exclusive.")
+		os.Exit(-1)
+	}
 
 	// Profiling service.
 	go func() {
@@ -51,7 +85,7 @@ func main() {
 
 	dataStore = influxdb2.NewStore(url, org, bucket, token)
 
-	http.HandleFunc("/sensordata", sensorDataHandler)
+	http.HandleFunc("/sensordata", authenticate(sensorDataHandler))
 	log.Fatal(http.ListenAndServe(host+":"+port, nil))
 }
 
@@ -68,22 +102,95 @@ func sensorDataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	var reading models.RawData
-	err = json.Unmarshal(body, &reading)
+	objects, isBatch, err := splitBody(body)
 	if err != nil {
 		log.Printf("An error occurred: %q.\n", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if !isBatch {
+		if status, err := store(objects[0]); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		http.Error(w, "success", http.StatusOK)
+		return
+	}
+
+	// Rejected objects of a batch are reported by index, the others are stored.
+	var rejected sender.BatchError
+	for i, obj := range objects {
+		status, err := store(obj)
+		if status == http.StatusInternalServerError {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if err != nil {
+			rejected.Errors = append(rejected.Errors, &sender.IndexError{Index: i, Err: err.Error()})
+		}
+	}
+	if len(rejected.Errors) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMultiStatus)
+		json.NewEncoder(w).Encode(rejected)
+		return
+	}
+
+	http.Error(w, "success", http.StatusOK)
+}
+
+// splitBody returns the JSON objects of a request body: a single object, a JSON array
+// or NDJSON (one object per line). Arrays and NDJSON with several objects are batches.
+func splitBody(body []byte) ([]json.RawMessage, bool, error) {
+	var objects []json.RawMessage
+
+	if b := bytes.TrimSpace(body); len(b) > 0 && b[0] == '[' {
+		err := json.Unmarshal(b, &objects)
+		return objects, true, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var obj json.RawMessage
+		err := d.Decode(&obj)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		objects = append(objects, obj)
+	}
+	if len(objects) == 0 {
+		return nil, false, errors.New("empty body")
+	}
+
+	return objects, len(objects) > 1, nil
+}
+
+// store validates and saves a reading. It returns the http status and the error to report.
+func store(obj json.RawMessage) (int, error) {
+	var reading models.RawData
+	err := json.Unmarshal(obj, &reading)
+	if err != nil {
+		log.Printf("An error occurred: %q.\n", err)
+		return http.StatusBadRequest, err
+	}
+
+	err = reading.CheckUnits()
+	if err != nil {
+		log.Printf("An error occurred: %q.\n", err)
+		return http.StatusBadRequest, err
+	}
+
 	log.Print(".")
 
 	err = dataStore.Write(reading)
 	if err != nil {
 		log.Printf("An error occurred: %q.", err)
-		http.Error(w, "unable to save data", http.StatusInternalServerError)
-		return
+		return http.StatusInternalServerError, errors.New("unable to save data")
 	}
 
-	http.Error(w, "success", http.StatusOK)
+	return http.StatusOK, nil
 }
@@ -1,6 +1,13 @@
 // Package models provide common data structures for matschmazia tools.
 package models // import "goex/ltser/matschmazia/models"
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
 // RawData contains the raw data coming from the sensors (all in string format).
 // More information on: https://browser.lter.eurac.edu/p/info.md
 type RawData struct {
@@ -21,4 +28,112 @@ type RawData struct {
 	WindSpeed         string `json:"wind_speed"`        // Undocumented.
 	WindSpeedAvg      string `json:"wind_speed_avg"`    // Wind speed in m/s.
 	WindSpeedMax      string `json:"wind_speed_max"`    // Wind gust in m/s.
+
+	Units map[string]string `json:"units,omitempty"` // Optional measurement unit of each field.
+}
+
+// TimeLayout is the format of RawData.Time.
+const TimeLayout = "2006-01-02 15:04:05"
+
+// TimeLocation is the time zone of RawData.Time: UTC +1, all year round.
+var TimeLocation = time.FixedZone("UTC+1", 60*60)
+
+// ParseTime parses a RawData.Time.
+func ParseTime(s string) (time.Time, error) {
+	return time.ParseInLocation(TimeLayout, s, TimeLocation)
+}
+
+// FieldMeasurements maps the RawData json fields to the measurement they contain.
+var FieldMeasurements = map[string]Measurement{
+	"air_t_avg":         Temperature,
+	"wind_speed":        WindSpeed,
+	"wind_speed_avg":    WindSpeed,
+	"wind_speed_max":    WindGust,
+	"air_rh_avg":        Humidity,
+	"precip_rt_nrt_tot": Precipitations,
+	"snow_height":       Snow,
+}
+
+// CheckUnits verifies that the units sent along with the data, if any,
+// match the units of the corresponding measurements.
+func (rd RawData) CheckUnits() error {
+	for field, unit := range rd.Units {
+		m, ok := FieldMeasurements[field]
+		if !ok || unit == "" {
+			continue
+		}
+		if !m.HasUnit(unit) {
+			return fmt.Errorf("unit %q of field %q does not match %s unit %q", unit, field, m, m.Unit())
+		}
+	}
+	return nil
+}
+
+// Validate checks that the data can be stored as expected: time in TimeLayout, station
+// not empty and, if stations is not empty, one of them, coordinates in range, numeric
+// fields parseable (empty values are missing values) and units matching (see CheckUnits).
+// It returns all the problems found.
+func (rd RawData) Validate(stations []string) []error {
+	var errs []error
+
+	if _, err := ParseTime(rd.Time); err != nil {
+		errs = append(errs, fmt.Errorf("invalid time %q", rd.Time))
+	}
+	if rd.Station == "" {
+		errs = append(errs, errors.New("missing station"))
+	} else if len(stations) > 0 && !contains(stations, rd.Station) {
+		errs = append(errs, fmt.Errorf("unknown station %q", rd.Station))
+	}
+	if err := checkRange("latitude", rd.Latitude, -90, 90); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkRange("longitude", rd.Longitude, -180, 180); err != nil {
+		errs = append(errs, err)
+	}
+
+	numbers := []struct{ field, value string }{
+		{"altitude", rd.Altitude},
+		{"elevation", rd.Elevation},
+		{"air_rh_avg", rd.AirRelHumidityAvg},
+		{"air_t_avg", rd.AirTempAvg},
+		{"nr_up_sw_avg", rd.NrUpSwAvg},
+		{"precip_rt_nrt_tot", rd.PrecipRtNrtTot},
+		{"snow_height", rd.SnowHeight},
+		{"sr_avg", rd.SrAvg},
+		{"wind_dir", rd.WindDir},
+		{"wind_speed", rd.WindSpeed},
+		{"wind_speed_avg", rd.WindSpeedAvg},
+		{"wind_speed_max", rd.WindSpeedMax},
+	}
+	for _, n := range numbers {
+		if _, err := strconv.ParseFloat(n.value, 64); n.value != "" && err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q", n.field, n.value))
+		}
+	}
+
+	if err := rd.CheckUnits(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+func checkRange(field, value string, min, max float64) error {
+	if value == "" {
+		return fmt.Errorf("missing %s", field)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < min || v > max {
+		return fmt.Errorf("invalid %s %q", field, value)
+	}
+	return nil
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
 }
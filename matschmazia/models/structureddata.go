@@ -1,6 +1,9 @@
 package models // import "goex/ltser/matschmazia/models"
 
-import "goex/ltser/timeseries"
+import (
+	"goex/ltser/timeseries"
+	"strings"
+)
 
 // Measurement represents a measure type.
 type Measurement struct {
@@ -23,6 +26,21 @@ func (m Measurement) String() string {
 	return m.name
 }
 
+// HasUnit reports whether u is the measurement unit or one of its common notations.
+// Case, spaces and enclosing brackets are ignored (e.g. "[°C]" matches "Celsius").
+func (m Measurement) HasUnit(u string) bool {
+	u = strings.ToLower(strings.Trim(u, " []()"))
+	if u == strings.ToLower(m.unit) {
+		return true
+	}
+	for _, a := range unitAliases[m.unit] {
+		if u == a {
+			return true
+		}
+	}
+	return false
+}
+
 // Available measurement.
 var (
 	Temperature    = Measurement{"temperature", "Celsius", "15 min average"}
@@ -33,6 +51,15 @@ var (
 	Snow           = Measurement{"snow", "m", ""} // Undocumented interval.
 )
 
+// Lower case notations accepted for each measurement unit.
+var unitAliases = map[string][]string{
+	"Celsius":    {"°c", "degc", "c", "deg c"},
+	"m/s":        {"m s-1", "m*s-1", "ms-1", "m/sec"},
+	"Percentage": {"%", "percent", "% rh"},
+	"mm":         {"millimeters", "mm/15min"},
+	"m":          {"meters"},
+}
+
 // Location represents a geographic position.
 type Location struct {
 	Altitude  int
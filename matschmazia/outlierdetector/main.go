@@ -27,6 +27,14 @@ var (
 
 var dataStore db.ReadWriter
 
+// Long names of the flags, accepted in configuration files and environment variables (e.g. OUTLIERDETECTOR_TOKEN).
+var flagAliases = []ext.Alias{
+	{Name: "url", Flag: "u"},
+	{Name: "org", Flag: "o"},
+	{Name: "bucket", Flag: "b"},
+	{Name: "token", Flag: "t"},
+}
+
 func init() {
 	flag.StringVar(&url, "u", "", "Target url of InfluxDB instance.")
 	flag.StringVar(&org, "o", "", "Target organization.")
@@ -38,7 +46,10 @@ func init() {
 }
 
 func main() {
-	flag.Parse()
+	if err := ext.Configure(flag.CommandLine, "OUTLIERDETECTOR", flagAliases, os.Args[1:]); err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		os.Exit(-1)
+	}
 
 	if url == "" || org == "" || bucket == "" || token == "" || from.Value().IsZero() || station == "" {
 		fmt.Fprintln(flag.CommandLine.Output(), "Missing or empty parameter.")
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A checkpoint records, for each input file, the highest line such that
+// it and all the lines before it have been acknowledged (sent or rejected).
+// The size of the files is recorded as well: a file found smaller on resume
+// has been rotated or truncated, and its lines are no more the recorded ones.
+type checkpoint struct {
+	Lines map[string]uint  `json:"lines"`
+	Sizes map[string]int64 `json:"sizes,omitempty"`
+}
+
+// loadCheckpoint reads a checkpoint file. A missing file is an empty checkpoint.
+func loadCheckpoint(filename string) (*checkpoint, error) {
+	c := &checkpoint{Lines: make(map[string]uint), Sizes: make(map[string]int64)}
+
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	if c.Lines == nil {
+		c.Lines = make(map[string]uint)
+	}
+	if c.Sizes == nil {
+		c.Sizes = make(map[string]int64)
+	}
+
+	return c, nil
+}
+
+// save writes the checkpoint file atomically, so that a crash never leaves it half written.
+func (c *checkpoint) save(filename string) error {
+	b, err := json.MarshalIndent(c, "", "   ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// A tracker follows the lines of a file from their reading to their acknowledgement.
+// Lines must be read in increasing order, but can be acknowledged in any order.
+type tracker struct {
+	pending  map[uint]bool // Lines read and not acknowledged yet.
+	lastRead uint
+	ended    bool // Whether the file has been read up to the end.
+}
+
+func newTracker(start uint) *tracker {
+	return &tracker{pending: make(map[uint]bool), lastRead: start}
+}
+
+// read records that a line has been read and is waiting for acknowledgement.
+func (t *tracker) read(line uint) {
+	t.pending[line] = true
+	t.lastRead = line
+}
+
+// ack records that a line has been acknowledged.
+func (t *tracker) ack(line uint) {
+	delete(t.pending, line)
+}
+
+// skip records that all the lines up to the given one need no acknowledgement.
+func (t *tracker) skip(line uint) {
+	if line > t.lastRead {
+		t.lastRead = line
+	}
+}
+
+// contiguous returns the highest line such that it and all the lines before it
+// have been acknowledged. Lines never read (e.g. filtered out) count as acknowledged.
+func (t *tracker) contiguous() uint {
+	if len(t.pending) == 0 {
+		return t.lastRead
+	}
+
+	min := t.lastRead
+	for l := range t.pending {
+		if l < min {
+			min = l
+		}
+	}
+	return min - 1
+}
+
+// pendingLines returns the lines read and not acknowledged yet, in increasing order.
+func (t *tracker) pendingLines() []uint {
+	lines := make([]uint, 0, len(t.pending))
+	for l := range t.pending {
+		lines = append(lines, l)
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i] < lines[j] })
+	return lines
+}
+
+// formatLines formats increasing line numbers as ranges, e.g. "3-5, 8".
+func formatLines(lines []uint) string {
+	var b strings.Builder
+	for i := 0; i < len(lines); i++ {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		if j > i {
+			fmt.Fprintf(&b, "%v-%v", lines[i], lines[j])
+		} else {
+			fmt.Fprintf(&b, "%v", lines[i])
+		}
+		i = j
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestContiguous(t *testing.T) {
+	for _, c := range []struct {
+		start     uint
+		read, ack []uint
+		skip      uint
+		out       uint
+	}{
+		{0, nil, nil, 0, 0},
+		{5, nil, nil, 0, 5},
+		{0, []uint{1, 2, 3}, nil, 0, 0},
+		{0, []uint{1, 2, 3}, []uint{1, 2, 3}, 0, 3},
+		{0, []uint{1, 2, 3}, []uint{1, 3}, 0, 1},
+		{0, []uint{1, 2, 3}, []uint{2, 3}, 0, 0},
+		{0, []uint{2, 5, 9}, []uint{2, 5}, 0, 8}, // Lines not read count as acknowledged.
+		{0, []uint{2, 5}, []uint{2, 5}, 7, 7},
+		{0, []uint{2, 5}, []uint{2}, 7, 4},
+		{10, []uint{12}, nil, 0, 11},
+	} {
+		tr := newTracker(c.start)
+		for _, l := range c.read {
+			tr.read(l)
+		}
+		for _, l := range c.ack {
+			tr.ack(l)
+		}
+		tr.skip(c.skip)
+		if got := tr.contiguous(); got != c.out {
+			t.Errorf("contiguous() of read %v, acknowledged %v, skipped %v => %v != %v", c.read, c.ack, c.skip, got, c.out)
+		}
+	}
+}
+
+func TestPendingLines(t *testing.T) {
+	for _, c := range []struct {
+		read, ack []uint
+		out       string
+	}{
+		{nil, nil, ""},
+		{[]uint{3}, nil, "3"},
+		{[]uint{3, 4}, nil, "3-4"},
+		{[]uint{3, 4, 5, 8}, nil, "3-5, 8"},
+		{[]uint{1, 3, 5}, nil, "1, 3, 5"},
+		{[]uint{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, []uint{3, 7, 8}, "1-2, 4-6, 9-10"},
+		{[]uint{10, 11, 12}, []uint{12, 10, 11}, ""},
+	} {
+		tr := newTracker(0)
+		for _, l := range c.read {
+			tr.read(l)
+		}
+		for _, l := range c.ack {
+			tr.ack(l)
+		}
+		if got := formatLines(tr.pendingLines()); got != c.out {
+			t.Errorf("pending lines of read %v, acknowledged %v => %q != %q", c.read, c.ack, got, c.out)
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "checkpoint.json")
+
+	for _, c := range []struct {
+		in  *checkpoint // Saved, if not nil.
+		out *checkpoint // nil if loading fails.
+		raw string      // Written instead, if in is nil and raw not empty.
+	}{
+		{nil, &checkpoint{Lines: map[string]uint{}, Sizes: map[string]int64{}}, ""}, // Missing file.
+		{&checkpoint{Lines: map[string]uint{"a.csv": 10, "b.csv.gz": 3}, Sizes: map[string]int64{"a.csv": 400}},
+			&checkpoint{Lines: map[string]uint{"a.csv": 10, "b.csv.gz": 3}, Sizes: map[string]int64{"a.csv": 400}}, ""},
+		{nil, &checkpoint{Lines: map[string]uint{"a.csv": 10}, Sizes: map[string]int64{}}, `{"lines": {"a.csv": 10}}`},
+		{nil, &checkpoint{Lines: map[string]uint{}, Sizes: map[string]int64{}}, `{}`},
+		{nil, nil, `{"lines": `},
+		{nil, nil, `{"lines": {"a.csv": -1}}`},
+	} {
+		os.Remove(filename)
+		switch {
+		case c.in != nil:
+			if err := c.in.save(filename); err != nil {
+				t.Fatalf("save() returned error %v", err)
+			}
+		case c.raw != "":
+			ioutil.WriteFile(filename, []byte(c.raw), 0644)
+		}
+
+		got, err := loadCheckpoint(filename)
+		switch {
+		case c.out == nil && err == nil:
+			t.Errorf("loadCheckpoint() of %q should have returned an error", c.raw)
+		case c.out != nil && err != nil:
+			t.Errorf("loadCheckpoint() returned error %v", err)
+		case c.out != nil && !reflect.DeepEqual(got, c.out):
+			t.Errorf("loadCheckpoint() => %+v != %+v", got, c.out)
+		}
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("save() left %v files, want only the checkpoint file", len(files))
+	}
+}
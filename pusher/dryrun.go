@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"goex/ltser/matschmazia/db/influxdb2"
+	"goex/ltser/matschmazia/models"
+	"goex/ltser/sender"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A validator is a sender that sends nothing: it checks that the rows would be accepted by the
+// ingestor, as models.RawData, and counts the columns that the InfluxDB store would ignore.
+type validator struct {
+	stations []string
+	mu       sync.Mutex
+	ignored  map[string]uint // Rows count of each ignored column with a value.
+}
+
+// An invalidRowError is returned by a validator, marked as a rejection (see sender.Rejected),
+// for the rows that would be rejected.
+type invalidRowError struct {
+	errs []error
+}
+
+func (e *invalidRowError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return "invalid row: " + strings.Join(msgs, ", ")
+}
+
+func newValidator(stations []string) *validator {
+	return &validator{stations: stations, ignored: make(map[string]uint)}
+}
+
+// Send validates a json object as the ingestor would decode it.
+func (v *validator) Send(b []byte) error {
+	var rd models.RawData
+	if err := json.Unmarshal(b, &rd); err != nil {
+		return sender.Rejected(&invalidRowError{[]error{err}})
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return sender.Rejected(&invalidRowError{[]error{err}})
+	}
+	v.mu.Lock()
+	for k, val := range m {
+		if k != "units" && !contains(influxdb2.WrittenFields, k) && val != nil && val != "" {
+			v.ignored[k]++
+		}
+	}
+	v.mu.Unlock()
+
+	if errs := rd.Validate(v.stations); len(errs) > 0 {
+		return sender.Rejected(&invalidRowError{errs})
+	}
+	return nil
+}
+
+// printReport prints the columns with values that the InfluxDB store would ignore.
+func (v *validator) printReport() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.ignored) == 0 {
+		fmt.Fprintf(os.Stderr, "Dry run: no columns ignored by the InfluxDB store.\n")
+		return
+	}
+	columns := make([]string, 0, len(v.ignored))
+	for c := range v.ignored {
+		columns = append(columns, c)
+	}
+	sort.Strings(columns)
+	fmt.Fprintf(os.Stderr, "Dry run: columns ignored by the InfluxDB store:\n")
+	for _, c := range columns {
+		fmt.Fprintf(os.Stderr, "   %s (%v rows)\n", c, v.ignored[c])
+	}
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"goex/ltser/sender"
+	"reflect"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+	v := newValidator([]string{"B1", "B3"})
+
+	for _, c := range []struct {
+		row      string
+		rejected bool
+	}{
+		{`{"time":"2019-01-01 00:15:00","station":"B3","latitude":"46.68","longitude":"10.58","air_t_avg":"-3.5","snow_height":""}`, false},
+		{`{"time":"2019-01-01 00:15:00","station":"B1","latitude":"46.68","longitude":"10.58","sr_avg":"120","note":"ok"}`, false},
+		{`{"time":"2019-01-01","station":"B3"}`, true},
+		{`{"time":"2019-01-01 00:15:00","station":"B9"}`, true},
+		{`{"time":"2019-01-01 00:15:00"}`, true},
+		{`{"time":"2019-01-01 00:15:00","station":"B3","latitude":"95"}`, true},
+		{`{"time":"2019-01-01 00:15:00","station":"B3","air_t_avg":"cold"}`, true},
+		{`{"time":"2019-01-01 00:15:00","station":"B3","air_t_avg":"3","units":{"air_t_avg":"km/h"}}`, true},
+		{`{"time":"2019-01-01 00:15:00","station":"B3","air_t_avg":3}`, true}, // Numbers are sent as strings.
+		{`[]`, true},
+	} {
+		err := v.Send([]byte(c.row))
+		if (err != nil) != c.rejected || (err != nil && !sender.IsRejected(err)) {
+			t.Errorf("Send(%s) => %v, want rejected %v", c.row, err, c.rejected)
+		}
+	}
+
+	want := map[string]uint{"sr_avg": 1, "note": 1} // Not written by the InfluxDB store.
+	if !reflect.DeepEqual(v.ignored, want) {
+		t.Errorf("ignored columns => %v != %v", v.ignored, want)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// errRotated is returned by a followReader when its file has been rotated or truncated.
+var errRotated = errors.New("file rotated or truncated")
+
+// A followReader reads a growing file, as tail -f does: once waiting is set, at the end
+// of the file it polls for new data instead of returning io.EOF. It returns errRotated
+// when the file is replaced by a new one (e.g. by a log rotation) or truncated, and
+// errStopped when stop is closed while waiting.
+type followReader struct {
+	filename string
+	f        *os.File
+	offset   int64 // Bytes read from f.
+	poll     time.Duration
+	waiting  bool            // Until set, io.EOF is returned at the end of the file (e.g. while detecting the dialect).
+	stop     <-chan struct{} // When closed, waiting ends with errStopped.
+}
+
+func openFollow(filename string, poll time.Duration, stop <-chan struct{}) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &followReader{filename: filename, f: f, poll: poll, stop: stop}, nil
+}
+
+func (fr *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := fr.f.Read(p)
+		fr.offset += int64(n)
+		atomic.AddInt64(&bytesRead, int64(n))
+		if n > 0 || err != io.EOF || !fr.waiting {
+			return n, err
+		}
+
+		rotated, err := fr.rotated()
+		if err != nil {
+			return 0, err
+		}
+		if rotated {
+			return 0, errRotated
+		}
+		select {
+		case <-time.After(fr.poll):
+		case <-fr.stop:
+			return 0, errStopped
+		}
+	}
+}
+
+// rotated reports whether the file name now refers to a different file or the file has been truncated.
+// A missing file is not rotated yet: the new one may not have been created.
+func (fr *followReader) rotated() (bool, error) {
+	cur, err := fr.f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if cur.Size() < fr.offset {
+		return true, nil
+	}
+
+	fi, err := os.Stat(fr.filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(cur, fi), nil
+}
+
+func (fr *followReader) Close() error {
+	return fr.f.Close()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestFollowRotation reads a followed file that is rotated, handling the control messages as the
+// main goroutine does while saving checkpoints: run with -race.
+func TestFollowRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "data.csv")
+	rows := "time,a\n2019-01-01,1\n2019-01-01,2\n2019-01-01,3\n"
+	ioutil.WriteFile(filename, []byte(rows), 0644)
+
+	stopFollow := make(chan struct{})
+	defer close(stopFollow)
+	src := &source{name: filename, open: func() (io.ReadCloser, error) {
+		return openFollow(filename, time.Millisecond, stopFollow)
+	}}
+
+	defer func(n int) { rowsToRead, progress, trackers = n, nil, make(map[*source]*tracker) }(rowsToRead)
+	rowsToRead = 6 // Ends the reading after the rows of the new file.
+	progress = &checkpoint{Lines: make(map[string]uint), Sizes: make(map[string]int64)}
+	checkpointFile = filepath.Join(dir, "data.csv.checkpoint")
+	defer func() { checkpointFile = "" }()
+	trackers[src] = newTracker(0)
+
+	chData := []chan dataMsg{make(chan dataMsg)}
+	chCtrl := make(chan controlMsg)
+	go func() {
+		for range chData[0] {
+		}
+	}()
+	go read(chData, chCtrl, []*source{src})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		os.Rename(filename, filename+".1")
+		ioutil.WriteFile(filename, []byte(rows), 0644)
+	}()
+
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+loop:
+	for {
+		select {
+		case msg := <-chCtrl:
+			if msg.err == errEndOfReading {
+				break loop
+			}
+			if msg.err != nil && msg.err != errEndOfSource {
+				t.Fatalf("error %v on line %v", msg.err, msg.line)
+			}
+			track(msg)
+		case <-ticker.C:
+			saveCheckpoint()
+			printProgress([]*source{src})
+		case <-timeout:
+			t.Fatal("follow still reading after 10s")
+		}
+	}
+
+	if src.next == nil || src.next.next != nil {
+		t.Fatalf("rotated source not linked to the new one")
+	}
+	if got := trackers[src.next].lastRead; got != 4 {
+		t.Errorf("last line read of the new file = %v, want 4", got)
+	}
+}
+
+func TestFollowRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "data.csv")
+
+	for _, c := range []struct {
+		name   string
+		change func() // Of the file, after reading it.
+		out    bool
+	}{
+		{"unchanged", func() {}, false},
+		{"grown", func() { appendFile(filename, "2019-01-01,3\n") }, false},
+		{"truncated", func() { os.Truncate(filename, 0) }, true},
+		{"truncated and grown", func() { os.Truncate(filename, 0); appendFile(filename, "time,a\n") }, true},
+		{"renamed", func() { os.Rename(filename, filename+".1") }, false}, // The new file may not have been created yet.
+		{"removed", func() { os.Remove(filename) }, false},
+		{"replaced", func() { os.Rename(filename, filename+".1"); ioutil.WriteFile(filename, nil, 0644) }, true},
+		{"replaced by a larger file", func() {
+			os.Rename(filename, filename+".1")
+			ioutil.WriteFile(filename, []byte("time,a\n2019-01-01,1\n2019-01-01,2\n2019-01-01,3\n"), 0644)
+		}, true},
+	} {
+		ioutil.WriteFile(filename, []byte("time,a\n2019-01-01,1\n2019-01-01,2\n"), 0644)
+		r, err := openFollow(filename, time.Millisecond, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fr := r.(*followReader)
+		if _, err := ioutil.ReadAll(fr); err != nil { // Not waiting: up to io.EOF.
+			t.Fatal(err)
+		}
+
+		c.change()
+		got, err := fr.rotated()
+		if err != nil {
+			t.Errorf("rotated() when %s returned error %v", c.name, err)
+		}
+		if got != c.out {
+			t.Errorf("rotated() when %s => %v != %v", c.name, got, c.out)
+		}
+		fr.Close()
+		os.Remove(filename + ".1")
+	}
+}
+
+func appendFile(filename, s string) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	f.WriteString(s)
+	f.Close()
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"goex/ltser/csvjson"
+	"goex/ltser/filter"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type compression byte
+
+const (
+	noCompression compression = iota
+	gzipCompression
+	bzip2Compression
+	zipArchive
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	zipMagic   = []byte("PK\x03\x04")
+)
+
+// A source is a csv stream to be pushed: a plain file, a compressed file or an entry of a zip archive.
+type source struct {
+	name       string // Name used in messages and checkpoints.
+	open       func() (io.ReadCloser, error)
+	size       int64           // Bytes of the file or, for zip entries, of the uncompressed entry. Read bytes are counted in bytesRead.
+	resumeLine uint            // Lines up to this one are skipped.
+	dialect    csvjson.Dialect // Set by the reader when the source is opened.
+	stats      sourceStats
+	next       *source // Set by track when a followed file is rotated: the source of the new file.
+}
+
+// sourceStats counts the rows of a source. Read, filtered and skipped rows are counted by
+// the reader, sent and rejected rows by the main goroutine while handling control messages.
+type sourceStats struct {
+	read, filtered, skipped, sent, rejected uint
+}
+
+func (s *sourceStats) add(o sourceStats) {
+	s.read += o.read
+	s.filtered += o.filtered
+	s.skipped += o.skipped
+	s.sent += o.sent
+	s.rejected += o.rejected
+}
+
+func (s sourceStats) String() string {
+	return fmt.Sprintf("%v read, %v filtered out, %v skipped, %v sent, %v rejected", s.read, s.filtered, s.skipped, s.sent, s.rejected)
+}
+
+// sourcesOf returns the csv streams contained in a file. Compressed files (gzip, bzip2)
+// are decompressed on the fly. For zip archives, each csv entry is returned in turn or,
+// if entry is not empty, only the entry with that name.
+func sourcesOf(filename, entry string) ([]*source, error) {
+	c, err := compressionOf(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if c == zipArchive {
+		return zipSources(filename, entry)
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	src := &source{name: filename, size: fi.Size()}
+	switch c {
+	case gzipCompression:
+		src.open = func() (io.ReadCloser, error) { return openGzip(filename) }
+	case bzip2Compression:
+		src.open = func() (io.ReadCloser, error) { return openBzip2(filename) }
+	default:
+		src.open = func() (io.ReadCloser, error) { return openPlain(filename) }
+	}
+
+	return []*source{src}, nil
+}
+
+// compressionOf detects the compression of a file by its magic bytes or, failing that, by its extension.
+func compressionOf(filename string) (compression, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return noCompression, err
+	}
+	defer f.Close()
+
+	magic, err := bufio.NewReader(f).Peek(len(zipMagic))
+	if err != nil && err != io.EOF {
+		return noCompression, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzipCompression, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2Compression, nil
+	case bytes.HasPrefix(magic, zipMagic):
+		return zipArchive, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz":
+		return gzipCompression, nil
+	case ".bz2":
+		return bzip2Compression, nil
+	case ".zip":
+		return zipArchive, nil
+	}
+
+	return noCompression, nil
+}
+
+// A readCloser reads from a decompressor and closes the underlying files.
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (rc *readCloser) Close() error {
+	var err error
+	for _, c := range rc.closers {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func openPlain(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{Reader: countingReader{f}, closers: []io.Closer{f}}, nil
+}
+
+func openGzip(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(countingReader{f})
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &readCloser{Reader: gz, closers: []io.Closer{gz, f}}, nil
+}
+
+func openBzip2(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &readCloser{Reader: bzip2.NewReader(countingReader{f}), closers: []io.Closer{f}}, nil
+}
+
+// zipSources returns the csv entries of a zip archive, in archive order.
+func zipSources(filename, entry string) ([]*source, error) {
+	z, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	defer z.Close()
+
+	var sources []*source
+	for _, f := range z.File {
+		name := f.Name
+		if entry != "" && name != entry || entry == "" && !strings.EqualFold(filepath.Ext(name), ".csv") {
+			continue
+		}
+		sources = append(sources, &source{
+			name: filename + ":" + name,
+			size: int64(f.UncompressedSize64),
+			open: func() (io.ReadCloser, error) { return openZipEntry(filename, name) },
+		})
+	}
+	if len(sources) == 0 && entry != "" {
+		return nil, fmt.Errorf("%s: entry %q not found", filename, entry)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%s: no .csv entries found", filename)
+	}
+
+	return sources, nil
+}
+
+// openZipEntry opens an entry of a zip archive. Closing it closes the archive.
+func openZipEntry(filename, entry string) (io.ReadCloser, error) {
+	z, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for _, f := range z.File {
+		if f.Name == entry {
+			rc, err := f.Open()
+			if err != nil {
+				z.Close()
+				return nil, fmt.Errorf("%s:%s: %v", filename, entry, err)
+			}
+			return &readCloser{Reader: countingReader{rc}, closers: []io.Closer{rc, z}}, nil
+		}
+	}
+	z.Close()
+	return nil, fmt.Errorf("%s: entry %q not found", filename, entry)
+}
+
+// inputFiles expands a file name, a directory or a glob pattern into the list of data files to read,
+// sorted by name. Data files in a directory are the .csv files, possibly compressed, and the zip archives.
+func inputFiles(pattern string) ([]string, error) {
+	if strings.ContainsAny(pattern, "*?[") {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no files match %s", pattern)
+		}
+		sort.Strings(files)
+		return files, nil
+	}
+
+	fi, err := os.Stat(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{pattern}, nil
+	}
+
+	infos, err := ioutil.ReadDir(pattern) // Sorted by name.
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, fi := range infos {
+		if !fi.IsDir() && isDataFile(fi.Name()) {
+			files = append(files, filepath.Join(pattern, fi.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no data files in %s", pattern)
+	}
+
+	return files, nil
+}
+
+func isDataFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".gz" || ext == ".bz2" {
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(name, filepath.Ext(name))))
+	}
+	return ext == ".csv" || ext == ".zip"
+}
+
+// sortByFirstTime sorts the sources by the timestamp in the column timeColumn of their first row.
+// Sources whose first timestamp cannot be read keep their order, after the others.
+// The bytes read meanwhile are not counted in bytesRead: they are read again when pushing.
+func sortByFirstTime(sources []*source, timeColumn string) {
+	read := atomic.LoadInt64(&bytesRead)
+	defer atomic.StoreInt64(&bytesRead, read)
+
+	times := make(map[*source]time.Time, len(sources))
+	for _, src := range sources {
+		if t, err := firstTime(src, timeColumn); err == nil {
+			times[src] = t
+		} else {
+			log.Printf("%s: first timestamp unknown (%v)", src.name, err)
+		}
+	}
+
+	sort.SliceStable(sources, func(i, j int) bool {
+		ti, iok := times[sources[i]]
+		tj, jok := times[sources[j]]
+		return iok && (!jok || ti.Before(tj))
+	})
+}
+
+func firstTime(src *source, timeColumn string) (time.Time, error) {
+	jsonRdr, _, f, err := newReader(src)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	obj, err := jsonRdr.ReadObject()
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, ok := filter.ParseTime(fmt.Sprint(obj[timeColumn]))
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q in column %q", obj[timeColumn], timeColumn)
+	}
+
+	return t, nil
+}
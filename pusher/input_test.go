@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSortByFirstTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(h uint) { headersRows = h }(headersRows)
+	headersRows = 1
+
+	var sources []*source
+	for _, c := range []struct {
+		name, content string
+	}{
+		{"a.csv", "time,a\n2019-01-03 00:00,1\n"},
+		{"b.csv", "time,a\nnot a time,1\n"},
+		{"c.csv", "time,a\n2019-01-01 12:00,1\n2019-01-09 00:00,2\n"},
+		{"d.csv", "time,a\n2019-01-02,1\n"},
+	} {
+		filename := filepath.Join(dir, c.name)
+		ioutil.WriteFile(filename, []byte(c.content), 0644)
+		s, err := sourcesOf(filename, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, s...)
+	}
+
+	read := atomic.LoadInt64(&bytesRead)
+	sortByFirstTime(sources, "time")
+	var got []string
+	for _, src := range sources {
+		got = append(got, filepath.Base(src.name))
+	}
+	if want := []string{"c.csv", "d.csv", "a.csv", "b.csv"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sortByFirstTime() => %v != %v", got, want)
+	}
+	if n := atomic.LoadInt64(&bytesRead) - read; n != 0 {
+		t.Errorf("sortByFirstTime() counted %v bytes read, want none", n)
+	}
+}
+
+func TestCompressionOf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		name    string
+		content string
+		out     compression
+	}{
+		{"a.csv", "time,a\n", noCompression},
+		{"a.csv.gz", "\x1f\x8b\x08\x00", gzipCompression},
+		{"a.csv", "\x1f\x8b\x08\x00", gzipCompression}, // Content first.
+		{"a.csv.gz", "time,a\n", gzipCompression},      // Then extension.
+		{"a.csv.bz2", "BZh91AY", bzip2Compression},
+		{"a.csv.BZ2", "", bzip2Compression},
+		{"a.zip", "PK\x03\x04", zipArchive},
+		{"a.dat", "PK\x03\x04\x14\x00", zipArchive},
+		{"a.csv", "", noCompression},
+		{"a.csv", "P", noCompression},
+	} {
+		filename := filepath.Join(dir, c.name)
+		ioutil.WriteFile(filename, []byte(c.content), 0644)
+		got, err := compressionOf(filename)
+		if err != nil {
+			t.Fatalf("compressionOf(%s) returned error %v", c.name, err)
+		}
+		if got != c.out {
+			t.Errorf("compressionOf(%s with %q) => %v != %v", c.name, c.content, got, c.out)
+		}
+		os.Remove(filename)
+	}
+
+	if _, err := compressionOf(filepath.Join(dir, "missing.csv")); err == nil {
+		t.Errorf("compressionOf() of a missing file should have returned an error")
+	}
+}
+
+func TestInputFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"b.csv", "a.csv.gz", "c.CSV.bz2", "d.zip", "notes.txt", "e.gz", "empty/"} {
+		if filepath.Base(name) != name {
+			os.Mkdir(filepath.Join(dir, name), 0755)
+			continue
+		}
+		ioutil.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	path := func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+		return paths
+	}
+
+	for _, c := range []struct {
+		pattern string
+		out     []string // nil if an error is expected.
+	}{
+		{dir, path("a.csv.gz", "b.csv", "c.CSV.bz2", "d.zip")},
+		{filepath.Join(dir, "*.csv*"), path("a.csv.gz", "b.csv")},
+		{filepath.Join(dir, "?.*"), path("a.csv.gz", "b.csv", "c.CSV.bz2", "d.zip", "e.gz")},
+		{filepath.Join(dir, "notes.txt"), path("notes.txt")}, // A file given by name is read whatever its extension.
+		{filepath.Join(dir, "*.json"), nil},
+		{filepath.Join(dir, "missing.csv"), nil},
+		{filepath.Join(dir, "empty"), nil},
+		{filepath.Join(dir, "[a"), nil},
+	} {
+		got, err := inputFiles(c.pattern)
+		switch {
+		case c.out == nil && err == nil:
+			t.Errorf("inputFiles(%s) => %v, should have returned an error", c.pattern, got)
+		case c.out != nil && err != nil:
+			t.Errorf("inputFiles(%s) returned error %v", c.pattern, err)
+		case c.out != nil && !reflect.DeepEqual(got, c.out):
+			t.Errorf("inputFiles(%s) => %v != %v", c.pattern, got, c.out)
+		}
+	}
+}
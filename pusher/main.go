@@ -3,18 +3,28 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
 	"goex/ltser/csvjson"
 	ext "goex/ltser/extensions"
+	"goex/ltser/filter"
 	"goex/ltser/sender"
+	batchsender "goex/ltser/sender/batch"
+	dlqsender "goex/ltser/sender/dlq"
+	filesender "goex/ltser/sender/file"
 	httpsender "goex/ltser/sender/http"
+	multisender "goex/ltser/sender/multi"
 	stdoutsender "goex/ltser/sender/stdout"
+	"hash/fnv"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -22,10 +32,41 @@ import (
 const (
 	defFilename       = "./data.csv"
 	defHeadersRows    = 1
+	defHeadersMode    = "first"
+	defHeadersSep     = "_"
+	defOrder          = "name"
+	defTimeColumn     = "time"
 	noRowsLimit       = -1
 	noURL             = ""
 	defBufferSize     = 1
 	defMaxConcurrency = 1
+	defPoll           = time.Second
+	defGracePeriod    = 10 * time.Second
+	defBatchBytes     = 1 << 20
+	defLinger         = time.Second
+	defBatchFormat    = "array"
+	defAttempts       = 10
+	defRetryDelay     = 100 * time.Millisecond
+	defRetryMaxDelay  = 30 * time.Second
+	defRetryJitter    = 100 * time.Millisecond
+	defRetryMaxTime   = 2 * time.Minute
+	defTimeout        = 30 * time.Second
+	defMaxIdleConns   = 32
+	defFsync          = "rotate"
+	defDLQCooldown    = 30 * time.Second
+	defTeeMode        = "all"
+	checkpointEvery   = time.Second
+	cancelWait        = time.Second
+	checkpointSuffix  = ".checkpoint"
+	globCheckpoint    = "pusher" + checkpointSuffix
+)
+
+// Exit codes.
+const (
+	exitOK          = 0   // All the rows have been sent.
+	exitFatal       = 1   // Invalid parameters, or a fatal error stopped the pusher.
+	exitRejected    = 3   // All the rows have been processed, but some of them have been rejected.
+	exitInterrupted = 130 // The pusher has been stopped by a signal.
 )
 
 type task byte
@@ -39,125 +80,883 @@ type controlMsg struct {
 	err     error
 	isFatal bool
 	origin  task
+	src     *source
 	line    uint
+	raw     string  // Lines of the row, as read.
+	next    *source // With errEndOfSource, the source of the new file after a rotation (see source.next).
 }
 
 type dataMsg struct {
 	data []byte
+	src  *source
 	line uint
+	raw  string
 }
 
 var (
 	filename        string
+	zipEntry        string
+	order           string
+	timeColumn      string
 	headersRows     uint
+	headersMode     string
+	headersSep      string
+	metadataNames   ext.StringListFlag
+	embedMetadata   ext.StringListFlag
 	rowsToRead      int
 	targetURL       string
 	bufferSize      ext.NotZeroUint32Flag
 	maxConcurrency  ext.NotZeroUint32Flag
-	jsonRdr         csvjson.Reader
+	keyColumn       string
+	batchSize       ext.NotZeroUint32Flag
+	batchBytes      int
+	linger          time.Duration
+	batchFormat     string
+	attempts        uint
+	retryDelay      time.Duration
+	retryMaxDelay   time.Duration
+	retryJitter     time.Duration
+	retryMaxTime    time.Duration
+	authToken       string
+	authUser        string
+	authPassword    string
+	hmacKey         string
+	timeout         time.Duration
+	tlsCA           string
+	tlsCert         string
+	tlsKey          string
+	tlsServerName   string
+	proxy           string
+	maxConns        int
+	maxIdleConns    int
+	outFile         string
+	rotateBytes     int64
+	rotateAge       time.Duration
+	gzipOut         bool
+	fsync           string
+	dlqDir          string
+	dlqCooldown     time.Duration
+	replayDLQ       bool
+	sendTimeout     time.Duration
+	teeTargets      ext.StringListFlag
+	teeMode         string
+	inferTypes      bool
+	inferColumns    ext.StringListFlag
+	nullValues      ext.StringListFlag
+	schemaFile      string
+	delimiter       string
+	encoding        string
+	decimal         string
+	rejectsFile     string
+	where           string
+	checkpointFile  string
+	resume          bool
+	follow          bool
+	poll            time.Duration
+	gracePeriod     time.Duration
+	showProgress    bool
+	summaryFile     string
+	dryRun          bool
+	stations        ext.StringListFlag
+	replaySpeed     string
+	rebase          bool
+	dialectHint     csvjson.Dialect
+	hdrMode         csvjson.HeadersMode
+	schema          *csvjson.Schema
+	indent          bool
+	rowsFilter      *filter.Expr
+	pacer           *replayer
+	rejects         *rejectsWriter
+	progress        *checkpoint
+	trackers        = make(map[*source]*tracker)
 	dataSender      sender.Sender
-	chData          chan dataMsg
+	ctxSender       sender.ContextSender // dataSender, to send rows with sendCtx.
+	batcher         *batchsender.Sender
+	tee             *multisender.Sender
+	chData          []chan dataMsg
 	chControl       chan controlMsg
+	chStop          = make(chan struct{})
+	chSignal        = make(chan os.Signal, 1)
 	errEndOfSending = errors.New("End Of Sending")
-	endOfSendingMsg = controlMsg{err: errEndOfSending, isFatal: false, origin: senderTask, line: 0}
-	totalLines      uint
+	errEndOfReading = errors.New("End Of Reading")
+	errEndOfSource  = errors.New("End Of Source")
+	errStopped      = errors.New("Stopped")
 )
 
+// Context of the rows being sent, cancelled at the end of the grace period.
+var sendCtx, cancelSends = context.WithCancel(context.Background())
+
+// Long names of the flags, accepted in configuration files and environment variables (e.g. PUSHER_URL).
+var flagAliases = []ext.Alias{
+	{Name: "file", Flag: "f"},
+	{Name: "headers", Flag: "h"},
+	{Name: "headers-mode", Flag: "hm"},
+	{Name: "headers-separator", Flag: "hsep"},
+	{Name: "max-rows", Flag: "m"},
+	{Name: "url", Flag: "u"},
+	{Name: "buffer", Flag: "b"},
+	{Name: "concurrency", Flag: "c"},
+	{Name: "delimiter", Flag: "d"},
+	{Name: "encoding", Flag: "enc"},
+}
+
 func init() {
-	flag.StringVar(&filename, "f", defFilename, "Data .CSV file name.")
-	flag.UintVar(&headersRows, "h", defHeadersRows, "Number of headers rows. First one is taken, the others are skipped.")
+	flag.StringVar(&filename, "f", defFilename, "Data .CSV file name, directory or glob pattern. Files compressed with gzip or bzip2 and zip archives of .CSV files are read as well.")
+	flag.StringVar(&zipEntry, "entry", "", "Name of the zip archive entry to read. If empty, every .CSV entry is read in turn.")
+	flag.StringVar(&order, "order", defOrder, "Order of the files of a directory or glob pattern: \"name\" or \"time\" (timestamp of the first row).")
+	flag.StringVar(&timeColumn, "time-col", defTimeColumn, "Name of the column with the timestamp of the rows.")
+	flag.UintVar(&headersRows, "h", defHeadersRows, "Number of headers rows. See -hm for the use of the rows after the first one.")
+	flag.StringVar(&headersMode, "hm", defHeadersMode, "Headers mode: \"first\" skips the rows after the first one, \"composite\" joins them into keys, \"metadata\" keeps them as metadata.")
+	flag.StringVar(&headersSep, "hsep", defHeadersSep, "Separator of composite keys.")
+	flag.Var(&metadataNames, "hnames", "Comma separated names of the metadata rows (e.g. \"units,descriptions\").")
+	flag.Var(&embedMetadata, "embed", "Comma separated names of the metadata rows forwarded in each JSON (e.g. \"units\").")
 	flag.IntVar(&rowsToRead, "m", noRowsLimit, "Number of rows to read. Use -1 for no rows limit.")
 	flag.StringVar(&targetURL, "u", noURL, "Target URL. If empty string, data are logged on StdOut.")
 	flag.Var(&bufferSize, "b", "Buffer size while reading.")
-	flag.Var(&maxConcurrency, "c", "Max concurrency. If greater than 1, sequential data processing is not guaranteed.")
+	flag.Var(&maxConcurrency, "c", "Max concurrency. If greater than 1, sequential data processing is not guaranteed, except for rows with the same -key.")
+	flag.Var(&batchSize, "batch", "Max number of rows sent in a single request, as a JSON array or NDJSON (see -batch-format). 1 means no batching.")
+	flag.IntVar(&batchBytes, "batch-bytes", defBatchBytes, "Max size in bytes of a batch of rows.")
+	flag.DurationVar(&linger, "linger", defLinger, "Max time waited for a batch to fill, after its first row.")
+	flag.StringVar(&batchFormat, "batch-format", defBatchFormat, "Format of the batches: \"array\" (JSON array) or \"ndjson\".")
+	flag.UintVar(&attempts, "attempts", defAttempts, "Max attempts of each request to the target URL, retries included. Only network errors and 5xx, 408 and 429 responses are retried.")
+	flag.DurationVar(&retryDelay, "retry-delay", defRetryDelay, "Delay before the first retry, doubled at each retry (unless the response has a Retry-After header).")
+	flag.DurationVar(&retryMaxDelay, "retry-max-delay", defRetryMaxDelay, "Max delay between retries, unless the target asks for more (Retry-After).")
+	flag.DurationVar(&retryJitter, "retry-jitter", defRetryJitter, "Max random time added to each delay between retries.")
+	flag.DurationVar(&retryMaxTime, "retry-max-time", defRetryMaxTime, "No more retries after this time since the first attempt of a request. 0 means no limit.")
+	flag.StringVar(&authToken, "auth-token", "", "Bearer token sent to the target URL. Better given as PUSHER_AUTH_TOKEN or PUSHER_AUTH_TOKEN_FILE.")
+	flag.StringVar(&authUser, "auth-user", "", "User for basic auth to the target URL (see -auth-password).")
+	flag.StringVar(&authPassword, "auth-password", "", "Password for basic auth to the target URL. Better given as PUSHER_AUTH_PASSWORD or PUSHER_AUTH_PASSWORD_FILE.")
+	flag.StringVar(&hmacKey, "hmac-key", "", "Shared key signing each request to the target URL with HMAC-SHA256 over body and timestamp. Better given as PUSHER_HMAC_KEY or PUSHER_HMAC_KEY_FILE.")
+	flag.DurationVar(&timeout, "timeout", defTimeout, "Timeout of each request to the target URL, response included. 0 means no timeout.")
+	flag.StringVar(&tlsCA, "tls-ca", "", "PEM bundle of the CAs trusted for the target URL, instead of the system ones.")
+	flag.StringVar(&tlsCert, "tls-cert", "", "PEM client certificate for mutual TLS with the target URL (see -tls-key).")
+	flag.StringVar(&tlsKey, "tls-key", "", "PEM private key of the client certificate.")
+	flag.StringVar(&tlsServerName, "tls-server-name", "", "Name expected in the certificate of the target URL, if not its host.")
+	flag.StringVar(&proxy, "proxy", "", "Proxy URL for the target URL, or \"direct\" for none. If empty, it is taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY.")
+	flag.IntVar(&maxConns, "max-conns", 0, "Max connections to the target host. 0 means no limit.")
+	flag.IntVar(&maxIdleConns, "max-idle-conns", defMaxIdleConns, "Max idle (keep-alive) connections to the target host.")
+	flag.StringVar(&outFile, "out", "", "NDJSON file the rows are written to, instead of sending them: files are numbered (e.g. data.ndjson gives data-000001.ndjson) and rotated by -rotate-bytes and -rotate-age.")
+	flag.Int64Var(&rotateBytes, "rotate-bytes", 0, "Max size in bytes of each -out file. 0 means no limit.")
+	flag.DurationVar(&rotateAge, "rotate-age", 0, "Max time each -out file is written to. 0 means no limit.")
+	flag.BoolVar(&gzipOut, "gzip", false, "Compress the -out files with gzip when they are rotated or closed.")
+	flag.StringVar(&fsync, "fsync", defFsync, "When the -out files are flushed to disk: \"none\", \"rotate\" (when closed), \"always\" (each row) or an interval (e.g. \"1s\").")
+	flag.StringVar(&dlqDir, "dlq", "", "Spool directory (dead-letter queue) of the rows that could not be sent after retries, instead of stopping: see -replay-dlq. Not with -tee.")
+	flag.DurationVar(&dlqCooldown, "dlq-cooldown", defDLQCooldown, "After a row has been spooled, time the following rows are spooled without trying the target URL.")
+	flag.BoolVar(&replayDLQ, "replay-dlq", false, "Send the rows spooled in the -dlq directory to the target URL, in order, removing each one once sent, and exit.")
+	flag.DurationVar(&sendTimeout, "send-timeout", 0, "Max time to send each row (or batch), retries included: then the request is cancelled. 0 means no limit.")
+	flag.Var(&teeTargets, "tee", "Comma separated list of further targets each row is sent to: URLs, or NDJSON files as -out (e.g. \"http://staging:8000/sensordata,rows.ndjson\").")
+	flag.StringVar(&teeMode, "tee-mode", defTeeMode, "When a row sent to several targets (see -tee) is sent: \"all\" of them succeed, \"any\" of them succeeds, or \"primary\" (the -u, -out or StdOut target) succeeds, the others being best effort.")
+	flag.StringVar(&keyColumn, "key", "", "Column (e.g. \"station\") whose values keep the order of their rows when sending concurrently: rows with the same key are sent in order by the same sender.")
+	flag.BoolVar(&inferTypes, "infer", false, "Emit numbers, booleans and nulls instead of strings for every column. Ingestor expects strings.")
+	flag.Var(&inferColumns, "infer-cols", "Comma separated list of columns whose types are inferred (when -infer is not set).")
+	flag.Var(&nullValues, "nulls", "Comma separated list of values emitted as null when types are inferred (default \",NaN\").")
+	flag.StringVar(&delimiter, "d", "", "Fields delimiter (e.g. \";\" or \"tab\"). If empty, it is detected.")
+	flag.StringVar(&encoding, "enc", "", "File encoding: utf-8, latin1 or windows-1252. If empty, it is detected.")
+	flag.StringVar(&decimal, "decimal", "", "Decimal separator: \".\" or \",\" (converted to \".\"). If empty, it is detected.")
+	flag.StringVar(&where, "where", "", "Filter expression selecting the rows to push (e.g. 'station == \"B3\" && time >= \"2019-01-01\"').")
+	flag.StringVar(&rejectsFile, "rejects", "", "Quarantine file for rows that could not be read or were rejected by the target: CSV if the extension is .csv, NDJSON otherwise.")
+	flag.StringVar(&checkpointFile, "checkpoint", "", "File recording the highest line such that all lines up to it have been sent or rejected.")
+	flag.BoolVar(&resume, "resume", false, "Skip the lines already recorded in the checkpoint file (default file name + \""+checkpointSuffix+"\").")
+	flag.BoolVar(&follow, "follow", false, "Keep reading the file as it grows, as tail -f does, also across rotations and truncations. Progress is saved in the checkpoint file.")
+	flag.DurationVar(&poll, "poll", defPoll, "Interval between checks for new data when following a file.")
+	flag.DurationVar(&gracePeriod, "grace", defGracePeriod, "On a fatal error or on SIGINT/SIGTERM, time given to the rows being sent to complete.")
+	flag.BoolVar(&showProgress, "progress", false, "Show a progress line (rows/s, bytes read, percent, ETA and errors) instead of a \"r\" and a \"s\" for each row read and sent.")
+	flag.StringVar(&summaryFile, "summary", "", "JSON file the summary of the run (rows, sent, rejected, retries, errors, duration and exit code) is written to.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Send nothing: validate each row as the Matsch/Mazia ingestor would and report the columns ignored by the InfluxDB store.")
+	flag.Var(&stations, "stations", "Comma separated list of the known station codes, checked by -dry-run. If empty, any station is accepted.")
+	flag.StringVar(&replaySpeed, "replay", "", "Replay the rows as if live, pacing them by the timestamps of the -time-col column: speed factor (e.g. \"1x\", \"60x\") or \"max\".")
+	flag.BoolVar(&rebase, "rebase", false, "When replaying, rewrite the timestamps relative to now.")
+	flag.StringVar(&schemaFile, "schema", "", "JSON file with the mapping schema (rename, merge, drop and constants) applied to each row.")
 }
 
 func main() {
 	defer trace("pusher")()
 
-	flag.Parse()
+	if err := ext.Configure(flag.CommandLine, "PUSHER", flagAliases, os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+		os.Exit(1)
+	}
 
-	// Open .CSV file.
-	f, err := os.Open(filename)
+	if replayDLQ {
+		os.Exit(replaySpool())
+	}
+
+	files, err := inputFiles(filename)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
 		os.Exit(1)
 	}
+	var sources []*source
+	for _, f := range files {
+		s, err := sourcesOf(f, zipEntry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+			os.Exit(1)
+		}
+		sources = append(sources, s...)
+	}
+	if follow {
+		if len(sources) != 1 || sources[0].name != files[0] {
+			fmt.Fprintf(os.Stderr, "An error occurred: -follow needs a single file")
+			os.Exit(1)
+		}
+		if c, err := compressionOf(files[0]); err != nil || c != noCompression {
+			fmt.Fprintf(os.Stderr, "An error occurred: -follow needs an uncompressed file")
+			os.Exit(1)
+		}
+		sources[0].open = func() (io.ReadCloser, error) { return openFollow(files[0], poll, chStop) }
+	}
+	if order != "name" && order != "time" {
+		fmt.Fprintf(os.Stderr, "An error occurred: unknown order %q", order)
+		os.Exit(1)
+	}
 
-	csvRdr := csv.NewReader(f)
-	csvRdr.ReuseRecord = true
+	dialectHint, err = parseDialect(delimiter, encoding, decimal)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+		os.Exit(1)
+	}
+	hdrMode, err = csvjson.ParseHeadersMode(headersMode)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+		os.Exit(1)
+	}
+	if schemaFile != "" {
+		schema, err = csvjson.LoadSchema(schemaFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+			os.Exit(1)
+		}
+	}
 
-	jsonRdr = *csvjson.NewReader(*csvRdr)
-	jsonRdr.HeadersRows = headersRows
+	if order == "time" {
+		sortByFirstTime(sources, timeColumn)
+	}
 
-	if targetURL == noURL {
+	if outFile != "" && (targetURL != noURL || dryRun || batchSize.Value() > 1) {
+		fmt.Fprintf(os.Stderr, "An error occurred: -out cannot be used with -u, -dry-run or -batch")
+		os.Exit(1)
+	}
+	if len(teeTargets.Value()) > 0 && (dryRun || dlqDir != "") {
+		// A spooled row may have been sent to some of the targets, and -replay-dlq sends it to -u only.
+		fmt.Fprintf(os.Stderr, "An error occurred: -tee cannot be used with -dry-run or -dlq")
+		os.Exit(1)
+	}
+
+	if dryRun {
+		dataSender = newValidator(stations.Value())
+		indent = false
+	} else if outFile != "" {
+		dataSender, err = newFileSender(outFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+			os.Exit(1)
+		}
+		indent = false
+	} else if targetURL == noURL {
 		dataSender = stdoutsender.NewSender()
-		jsonRdr.IndentFormat = true
-		jsonRdr.Indent = "   "
+		indent = true
 	} else {
-		dataSender = httpsender.NewSender(targetURL)
-		jsonRdr.IndentFormat = false
+		dataSender, err = newHTTPSender(targetURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+			os.Exit(1)
+		}
+		indent = false
+	}
+	if len(teeTargets.Value()) > 0 {
+		tee, err = newTee(dataSender)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+			os.Exit(1)
+		}
+		dataSender = tee
+		indent = false
 	}
+	if dlqDir != "" && !dryRun {
+		dlq := dlqsender.NewSender(dlqDir, dataSender)
+		dlq.Cooldown = dlqCooldown
+		dataSender = dlq
+	}
+
+	ctxSender = sender.WithContext(dataSender)
 
-	chData = make(chan dataMsg, bufferSize.Value())
+	if batchSize.Value() > 1 && !dryRun {
+		batcher = batchsender.NewSender(dataSender)
+		batcher.MaxRows = int(batchSize.Value())
+		batcher.MaxBytes = batchBytes
+		batcher.Linger = linger
+		switch batchFormat {
+		case "array":
+			batcher.Format = batchsender.JSONArray
+		case "ndjson":
+			batcher.Format = batchsender.NDJSON
+		default:
+			fmt.Fprintf(os.Stderr, "An error occurred: unknown batch format %q", batchFormat)
+			os.Exit(1)
+		}
+	}
+
+	if where != "" {
+		rowsFilter, err = filter.Parse(where)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "An error occurred: invalid filter (%v)", err)
+			os.Exit(1)
+		}
+	}
+
+	if replaySpeed != "" {
+		speed, err := parseSpeed(replaySpeed)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+			os.Exit(1)
+		}
+		pacer = &replayer{column: timeColumn, speed: speed, rebase: rebase}
+	} else if rebase {
+		fmt.Fprintf(os.Stderr, "An error occurred: -rebase needs -replay")
+		os.Exit(1)
+	}
+
+	if rejectsFile != "" {
+		rejects, err = newRejectsWriter(rejectsFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if (resume || follow) && checkpointFile == "" && len(files) == 1 && files[0] == filename {
+		checkpointFile = filename + checkpointSuffix
+	} else if (resume || follow) && checkpointFile == "" {
+		checkpointFile = globCheckpoint
+	}
+	if checkpointFile != "" {
+		progress, err = loadCheckpoint(checkpointFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "An error occurred: invalid checkpoint %s (%v)", checkpointFile, err)
+			os.Exit(1)
+		}
+	}
+	for _, src := range sources {
+		if resume {
+			src.resumeLine = progress.Lines[src.name]
+			if fi, err := os.Stat(src.name); err == nil && fi.Size() < progress.Sizes[src.name] {
+				log.Printf("%s is smaller than at the last checkpoint (rotated or truncated), reading it from the beginning", src.name)
+				src.resumeLine = 0
+			}
+		}
+		trackers[src] = newTracker(src.resumeLine)
+	}
+
+	chData = make([]chan dataMsg, 1)
+	if keyColumn != "" {
+		chData = make([]chan dataMsg, maxConcurrency.Value()) // A channel for each sender.
+	}
+	for i := range chData {
+		chData[i] = make(chan dataMsg, bufferSize.Value())
+	}
 	chControl = make(chan controlMsg)
+	signal.Notify(chSignal, os.Interrupt, syscall.SIGTERM)
 
-	go read(chData, chControl, &totalLines)
-	for i := uint32(0); i < maxConcurrency.Value(); i++ {
-		go send(chData, chControl)
+	go read(chData, chControl, sources)
+	for i := 0; i < int(maxConcurrency.Value()); i++ {
+		go send(chData[i%len(chData)], chControl)
 	}
 
+	// On a fatal error or a signal, the reader and the senders are stopped: the rows being sent
+	// are given gracePeriod to complete, then they are cancelled (and given cancelWait to report it)
+	// and the lines that have not been sent are reported.
 	var endOfSendCount uint32
+	var readingEnded, cancelled bool
+	var grace <-chan time.Time
+	code := exitOK
+	ticker := time.NewTicker(checkpointEvery)
+	defer ticker.Stop()
 
+loop:
 	for {
-		msg := <-chControl
+		var msg controlMsg
+		select {
+		case msg = <-chControl:
+		case <-ticker.C:
+			saveCheckpoint()
+			if showProgress {
+				printProgress(sources)
+			}
+			continue
+		case sig := <-chSignal:
+			if stopping() {
+				fmt.Fprintf(os.Stderr, "\n%v received again. Aborted.", sig)
+				cancelSends()
+				break loop
+			}
+			fmt.Fprintf(os.Stderr, "\n%v received, stopping (waiting up to %v for the rows being sent).", sig, gracePeriod)
+			code = exitInterrupted
+			grace = stop()
+			continue
+		case <-grace:
+			if cancelled {
+				break loop
+			}
+			fmt.Fprintf(os.Stderr, "\nRows still being sent after %v are cancelled and reported as not sent.", gracePeriod)
+			cancelSends()
+			cancelled = true
+			grace = time.After(cancelWait)
+			continue
+		}
 
-		if msg == endOfSendingMsg {
-			if endOfSendCount++; endOfSendCount == maxConcurrency.Value() {
-				break
+		switch msg.err {
+		case errEndOfReading:
+			readingEnded = true
+		case errEndOfSending:
+			endOfSendCount++
+		default:
+			if logMsg(msg) {
+				if !stopping() {
+					grace = stop()
+				}
+				code = exitFatal
 			}
-		} else {
-			logMsg(msg)
+			track(msg)
 		}
+		if readingEnded && endOfSendCount == maxConcurrency.Value() {
+			break loop
+		}
+	}
+
+	if showProgress {
+		printProgress(sources)
+	}
+	total := printSummary(sources)
+	if v, ok := dataSender.(*validator); ok {
+		v.printReport()
+	}
+	if stopping() {
+		printUnsent(sources)
+	}
+	if tee != nil {
+		printTargetFailures()
+	}
+	if n := spooled(); n > 0 {
+		fmt.Fprintf(os.Stderr, "%v requests spooled to %s: send them with -replay-dlq.\n", n, dlqDir)
+	}
+	if code == exitOK && total.rejected > 0 {
+		code = exitRejected
+	}
+	if !closeSender() || !closeRejects() || !saveCheckpoint() {
+		code = exitFatal
+	}
+	if summaryFile != "" {
+		if err := writeSummary(summaryFile, sources, code); err != nil {
+			fmt.Fprintf(os.Stderr, "An error occurred writing %s (%s).\n", summaryFile, err)
+			code = exitFatal
+		}
+	}
+	if code != exitOK {
+		os.Exit(code)
+	}
+}
+
+// stop tells the reader and the senders to stop, and returns the end of the grace period.
+func stop() <-chan time.Time {
+	close(chStop)
+	return time.After(gracePeriod)
+}
+
+// stopping reports whether the reader and the senders have been told to stop.
+func stopping() bool {
+	select {
+	case <-chStop:
+		return true
+	default:
+		return false
+	}
+}
 
-		//TODO: manage fatal error from senders. Stop reader and wait for results from other senders.
+// newReader opens a source and returns a csvjson.Reader of its content, configured by the flags,
+// and the recorder of the raw lines it reads.
+func newReader(src *source) (*csvjson.Reader, *lineRecorder, io.Closer, error) {
+	f, err := src.open()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	input, dialect, err := csvjson.Detect(f, dialectHint)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	src.dialect = dialect
+	if fr, ok := f.(*followReader); ok {
+		fr.waiting = true // The sample has been read: from now on wait for new data at the end of the file.
 	}
 
-	fmt.Fprintf(os.Stderr, "\nFinished processing %v lines.\n", totalLines)
+	lines := newLineRecorder(input)
+	csvRdr := csv.NewReader(lines)
+	csvRdr.Comma = dialect.Comma
+	csvRdr.ReuseRecord = true
+
+	jsonRdr := csvjson.NewReader(*csvRdr)
+	jsonRdr.HeadersRows = headersRows
+	jsonRdr.HeadersMode = hdrMode
+	jsonRdr.HeadersSeparator = headersSep
+	jsonRdr.MetadataNames = metadataNames.Value()
+	jsonRdr.EmbedMetadata = embedMetadata.Value()
+	jsonRdr.DecimalComma = dialect.Decimal == ','
+	jsonRdr.InferTypes = inferTypes
+	jsonRdr.InferColumns = inferColumns.Value()
+	if nullValues.Value() != nil {
+		jsonRdr.NullValues = nullValues.Value()
+	}
+	jsonRdr.Schema = schema
+	if indent {
+		jsonRdr.IndentFormat = true
+		jsonRdr.Indent = "   "
+	}
+
+	return jsonRdr, lines, f, nil
 }
 
-func read(chData chan<- dataMsg, chControl chan<- controlMsg, totalLines *uint) {
-	i := uint(0)
-	for i = 0; rowsToRead < 0 || i < uint(rowsToRead); i++ { // Cast only if >= 0.
-		jsonBytes, err := jsonRdr.Read()
+// read sends the rows of the sources to the senders, skipping the lines up to each source resumeLine.
+// For each row, the control message is sent before the data, so that lines are tracked before being sent.
+// An end of source message, carrying the last line read, is sent after the rows of each source read
+// up to the end. If there are several data channels, rows are dispatched by the value of their keyColumn.
+// Reading ends at the end of the sources, or as soon as the pusher is stopped.
+func read(chData []chan dataMsg, chControl chan<- controlMsg, sources []*source) {
+	var i uint
+
+	for _, src := range sources {
+		for src != nil && (rowsToRead < 0 || i < uint(rowsToRead)) && !stopping() { // Cast only if >= 0.
+			src = readSource(src, &i, chData, chControl)
+		}
+	}
+
+	for _, ch := range chData {
+		close(ch)
+	}
+	chControl <- controlMsg{err: errEndOfReading, origin: readerTask}
+}
+
+// readSource sends the rows of a source, counting them in i. If the source is followed
+// and its file is rotated or truncated, it returns the source of the new file (see source.next).
+func readSource(src *source, i *uint, chData []chan dataMsg, chControl chan<- controlMsg) *source {
+	jsonRdr, lines, f, err := newReader(src)
+	if err != nil {
+		chControl <- controlMsg{err: err, isFatal: true, origin: readerTask, src: src}
+		return nil
+	}
+	log.Printf("%s format: %v", src.name, src.dialect)
+	defer f.Close()
+
+	var last uint
+	for rowsToRead < 0 || *i < uint(rowsToRead) {
+		if stopping() {
+			return nil
+		}
+		var obj map[string]interface{}
+		err := jsonRdr.ReadHeaders()
+		lines.take() // The raw lines of the headers are not needed.
+		if err == nil {
+			obj, err = jsonRdr.ReadObject()
+		}
+		raw := lines.take()
 		if err == io.EOF {
 			break
 		}
+		if err == errStopped {
+			return nil
+		}
+		var rowErr *csvjson.RowError
+		if err != nil && err != errRotated && !errors.As(err, &rowErr) {
+			// Not a row that can be rejected, but a failure of the source (e.g. a truncated gzip):
+			// the same error would be returned again and again.
+			chControl <- controlMsg{err: err, isFatal: true, origin: readerTask, src: src}
+			return nil
+		}
+		if err == errRotated {
+			log.Printf("%s rotated or truncated, reading it from the beginning", src.name)
+			next := &source{name: src.name, open: src.open} // Linked to src by track, on the main goroutine.
+			chControl <- controlMsg{err: errEndOfSource, origin: readerTask, src: src, line: last, next: next}
+			return next
+		}
+		line := jsonRdr.Row() // Lines are numbered as in the file, headers included.
+		last = line
+		if line <= src.resumeLine {
+			src.stats.skipped++
+			continue
+		}
+		*i++
+		src.stats.read++
+		if err == nil && rowsFilter != nil && !rowsFilter.Match(obj) {
+			src.stats.filtered++
+			continue
+		}
+		if err == nil && pacer != nil {
+			pacer.replay(obj)
+		}
+		var jsonBytes []byte
+		if err == nil {
+			jsonBytes, err = jsonRdr.Marshal(obj)
+		}
+		chControl <- controlMsg{err: err, origin: readerTask, src: src, line: line, raw: raw}
 		if err == nil {
-			chData <- dataMsg{data: jsonBytes, line: i}
+			select {
+			case chData[keyIndex(obj, len(chData))] <- dataMsg{data: jsonBytes, src: src, line: line, raw: raw}:
+			case <-chStop:
+				return nil
+			}
 		}
-		chControl <- controlMsg{err: err, origin: readerTask, line: i}
 	}
 
-	close(chData)
-	*totalLines = i
+	chControl <- controlMsg{err: errEndOfSource, origin: readerTask, src: src, line: last}
+	return nil
 }
 
+// send sends the rows received from chData until it is closed or the pusher is stopped.
+// If batching, rows are sent in batches (see receive), each row getting its own error.
 func send(chData <-chan dataMsg, chControl chan<- controlMsg) {
 	for {
-		msg, more := <-chData
-		if !more {
-			chControl <- endOfSendingMsg
+		msgs, more := receive(chData)
+		if stopping() { // Rows received after a stop are not sent.
+			msgs = nil
+		}
+
+		ctx, cancel := sendContext()
+		var errs []error
+		switch {
+		case len(msgs) == 0:
+		case batcher != nil:
+			rows := make([][]byte, len(msgs))
+			for i, msg := range msgs {
+				rows[i] = msg.data
+			}
+			errs = batcher.SendBatchContext(ctx, rows)
+		default:
+			errs = []error{ctxSender.SendContext(ctx, msgs[0].data)}
+		}
+		cancel()
+
+		for i, msg := range msgs {
+			err := errs[i]
+			if errors.Is(err, context.Canceled) { // At the end of the grace period: reported as not sent.
+				err = errStopped
+			}
+			fatal := err != nil && !sender.IsRejected(err) // Rejected rows do not prevent sending the others.
+			chControl <- controlMsg{err: err, isFatal: fatal, origin: senderTask, src: msg.src, line: msg.line, raw: msg.raw}
+		}
+
+		if !more || stopping() {
+			chControl <- controlMsg{err: errEndOfSending, origin: senderTask}
 			return
 		}
+	}
+}
+
+// sendContext returns the context of a send, with the -send-timeout deadline if any.
+func sendContext() (context.Context, context.CancelFunc) {
+	if sendTimeout > 0 {
+		return context.WithTimeout(sendCtx, sendTimeout)
+	}
+	return context.WithCancel(sendCtx)
+}
+
+// receive returns the next rows of chData: one row or, if batching, up to batchSize rows,
+// waiting up to linger after the first one. It reports whether chData is still open.
+func receive(chData <-chan dataMsg) ([]dataMsg, bool) {
+	var msgs []dataMsg
+	var timeout <-chan time.Time
+
+	for len(msgs) < int(batchSize.Value()) {
+		select {
+		case msg, more := <-chData:
+			if !more {
+				return msgs, false
+			}
+			msgs = append(msgs, msg)
+			if timeout == nil {
+				timeout = time.After(linger)
+			}
+		case <-timeout:
+			return msgs, true
+		case <-chStop:
+			return msgs, true
+		}
+	}
+
+	return msgs, true
+}
+
+// keyIndex returns the index, between 0 and n-1, of the data channel of an object:
+// objects with the same keyColumn value have the same index.
+func keyIndex(obj map[string]interface{}, n int) int {
+	if n == 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	fmt.Fprint(h, obj[keyColumn])
+	return int(h.Sum32() % uint32(n))
+}
 
-		err := dataSender.Send(msg.data)
-		fatal := false
+// replaySpool sends the requests spooled in the -dlq directory to the target URL and returns the exit code.
+func replaySpool() int {
+	if dlqDir == "" || targetURL == noURL {
+		fmt.Fprintf(os.Stderr, "An error occurred: -replay-dlq needs -dlq and -u")
+		return exitFatal
+	}
+	httpSender, err := newHTTPSender(targetURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+		return exitFatal
+	}
+
+	sent, rejected, err := dlqsender.NewSender(dlqDir, httpSender).Replay()
+	for _, r := range rejected {
+		fmt.Fprintf(os.Stderr, "Rejected %v\n", r)
+	}
+	fmt.Fprintf(os.Stderr, "%s: %v sent, %v rejected\n", dlqDir, sent, len(rejected))
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "An error occurred: %v", err)
+		return exitFatal
+	case len(rejected) > 0:
+		return exitRejected
+	}
+	return exitOK
+}
+
+// newTee returns the sender of the rows to primary and to the -tee targets.
+func newTee(primary sender.Sender) (*multisender.Sender, error) {
+	var err error
+
+	targets := []sender.Sender{primary}
+	names := []string{targetURL}
+	switch {
+	case outFile != "":
+		names[0] = outFile
+	case targetURL == noURL:
+		names[0] = "StdOut"
+	}
+	for _, t := range teeTargets.Value() {
+		var s sender.Sender
+		if strings.HasPrefix(t, "http://") || strings.HasPrefix(t, "https://") {
+			s, err = newHTTPSender(t)
+		} else if batchSize.Value() > 1 {
+			err = errors.New("-tee files cannot be used with -batch")
+		} else {
+			s, err = newFileSender(t)
+		}
 		if err != nil {
-			fatal = true // TODO: Add error analysis logic here.
+			return nil, err
 		}
-		chControl <- controlMsg{err: err, isFatal: fatal, origin: senderTask, line: msg.line}
+		targets = append(targets, s)
+		names = append(names, t)
+	}
+
+	tee := multisender.NewSender(targets...)
+	tee.Names = names
+	tee.Mode, err = multisender.ParseMode(teeMode)
+	if err != nil {
+		return nil, err
+	}
+	return tee, nil
+}
+
+// newFileSender returns the sender to NDJSON files named after path, as configured by the flags.
+func newFileSender(path string) (*filesender.Sender, error) {
+	var err error
+
+	fileSender := filesender.NewSender(path)
+	fileSender.MaxBytes = rotateBytes
+	fileSender.MaxAge = rotateAge
+	fileSender.Gzip = gzipOut
+	fileSender.Sync, fileSender.SyncEvery, err = filesender.ParseSyncPolicy(fsync)
+	if err != nil {
+		return nil, err
+	}
+	return fileSender, nil
+}
+
+// newHTTPSender returns the sender to a target URL, as configured by the flags.
+func newHTTPSender(url string) (*httpsender.Sender, error) {
+	var err error
+
+	httpSender := httpsender.NewSender(url)
+	httpSender.MaxAttempts = attempts
+	httpSender.InitialDelay = retryDelay
+	httpSender.MaxDelay = retryMaxDelay
+	httpSender.MaxJitter = retryJitter
+	httpSender.MaxElapsed = retryMaxTime
+	httpSender.Auth, err = authOf(authToken, authUser, authPassword, hmacKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientOptions := httpsender.DefaultClientOptions()
+	clientOptions.Timeout = timeout
+	clientOptions.CAFile = tlsCA
+	clientOptions.CertFile = tlsCert
+	clientOptions.KeyFile = tlsKey
+	clientOptions.ServerName = tlsServerName
+	clientOptions.Proxy = proxy
+	clientOptions.MaxConnsPerHost = maxConns
+	clientOptions.MaxIdleConnsPerHost = maxIdleConns
+	httpSender.Client, err = httpsender.NewClient(clientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return httpSender, nil
+}
+
+// authOf returns the credentials of the requests to the target URL, nil if none.
+func authOf(token, user, password, key string) (httpsender.Auth, error) {
+	var auths httpsender.Auths
+
+	switch {
+	case token != "" && (user != "" || password != ""):
+		return nil, errors.New("-auth-token and -auth-user/-auth-password are exclusive")
+	case token != "":
+		auths = append(auths, httpsender.BearerAuth(token))
+	case user != "":
+		auths = append(auths, httpsender.BasicAuth(user, password))
+	case password != "":
+		return nil, errors.New("-auth-password needs -auth-user")
+	}
+	if key != "" {
+		auths = append(auths, httpsender.HMACAuth([]byte(key)))
+	}
+
+	if len(auths) == 0 {
+		return nil, nil
 	}
+	return auths, nil
+}
+
+// parseDialect converts format flags into a csvjson.Dialect. Empty flags are left for detection.
+func parseDialect(delimiter, encoding, decimal string) (csvjson.Dialect, error) {
+	d := csvjson.Dialect{Encoding: encoding}
+
+	switch delimiter {
+	case "":
+	case "tab", `\t`:
+		d.Comma = '\t'
+	default:
+		r := []rune(delimiter)
+		if len(r) != 1 {
+			return d, fmt.Errorf("invalid delimiter %q", delimiter)
+		}
+		d.Comma = r[0]
+	}
+
+	switch decimal {
+	case "":
+	case ".", ",":
+		d.Decimal = rune(decimal[0])
+	default:
+		return d, fmt.Errorf("invalid decimal separator %q", decimal)
+	}
+
+	return d, nil
 }
 
 func trace(message string) func() {
@@ -166,16 +965,192 @@ func trace(message string) func() {
 	return func() { log.Printf("exit %s (%s)", message, time.Since(start)) }
 }
 
-func logMsg(msg controlMsg) {
+// logMsg logs a control message and writes the rejected rows. It reports whether the pusher must stop.
+func logMsg(msg controlMsg) (fatal bool) {
+	if msg.err == errEndOfSource || msg.err == errStopped {
+		return false
+	}
+
+	if msg.err != nil {
+		errorCounts[errorCategory(msg)]++
+		if showProgress {
+			defer fmt.Fprintln(os.Stderr) // So that the progress line does not overwrite the message.
+		}
+	}
+	if msg.err != nil && !msg.isFatal && msg.line > 0 && rejects != nil { // Rows failed by a fatal error are not rejected: they are sent on -resume.
+		if err := rejects.write(msg); err != nil {
+			fmt.Fprintf(os.Stderr, "\nAn error occurred writing rejected %s (%s). Stopping.", position(msg), err)
+			return true
+		}
+	}
+
 	switch {
+	case msg.err == nil && showProgress:
 	case msg.err == nil && msg.origin == readerTask:
 		fmt.Fprintf(os.Stderr, "r")
 	case msg.err == nil && msg.origin == senderTask:
 		fmt.Fprintf(os.Stderr, "s")
 	case msg.isFatal:
-		fmt.Fprintf(os.Stderr, "\nAn error occurred on line %v (%s). Aborted.", msg.line, msg.err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "\nAn error occurred on %s (%s). Stopping.", position(msg), msg.err)
+		return true
 	default:
-		fmt.Fprintf(os.Stderr, "\nAn error occurred on line %v (%s).", msg.line, msg.err)
+		fmt.Fprintf(os.Stderr, "\nAn error occurred on %s (%s).", position(msg), msg.err)
+	}
+	return false
+}
+
+// position describes the line of a control message, e.g. "line 3 of data.csv".
+func position(msg controlMsg) string {
+	if msg.line == 0 {
+		return msg.src.name
+	}
+	return fmt.Sprintf("line %v of %s", msg.line, msg.src.name)
+}
+
+// track counts and records the lines read and acknowledged.
+// Lines that could not be read are acknowledged at once; lines failed with a fatal error are never.
+func track(msg controlMsg) {
+	lines, stats := trackers[msg.src], &msg.src.stats
+
+	switch {
+	case msg.err == errEndOfSource:
+		lines.skip(msg.line)
+		lines.ended = true
+		if msg.next != nil {
+			msg.src.next = msg.next
+			trackers[msg.next] = newTracker(0)
+		}
+	case msg.origin == readerTask && msg.line == 0: // An error of the source, not of a line.
+	case msg.origin == readerTask:
+		lines.read(msg.line)
+		if msg.err != nil {
+			stats.rejected++
+			lines.ack(msg.line)
+		}
+	case !msg.isFatal:
+		if msg.err != nil {
+			stats.rejected++
+		} else {
+			stats.sent++
+		}
+		lines.ack(msg.line)
+	}
+}
+
+// saveCheckpoint writes the checkpoint file, if any. It returns false if the file could not be written.
+func saveCheckpoint() bool {
+	if progress == nil {
+		return true
+	}
+	for src, lines := range trackers {
+		if src.next != nil { // Rotated away: its lines are no more in the file.
+			continue
+		}
+		progress.Lines[src.name] = lines.contiguous()
+		if fi, err := os.Stat(src.name); err == nil {
+			progress.Sizes[src.name] = fi.Size()
+		}
+	}
+	if err := progress.save(checkpointFile); err != nil {
+		fmt.Fprintf(os.Stderr, "\nAn error occurred saving checkpoint %s (%s).", checkpointFile, err)
+		return false
+	}
+	return true
+}
+
+// printSummary prints the rows count of each source and returns their totals.
+// The rows of the files that replaced a followed file are counted with it.
+func printSummary(sources []*source) sourceStats {
+	var total sourceStats
+	fmt.Fprintf(os.Stderr, "\nFinished processing %v files:\n", len(sources))
+	for _, src := range sources {
+		var stats sourceStats
+		rotations := -1
+		for s := src; s != nil; s = s.next {
+			stats.add(s.stats)
+			rotations++
+		}
+		if rotations > 0 {
+			fmt.Fprintf(os.Stderr, "   %s: %v (rotated %v times)\n", src.name, stats, rotations)
+		} else {
+			fmt.Fprintf(os.Stderr, "   %s: %v\n", src.name, stats)
+		}
+		total.add(stats)
+	}
+	fmt.Fprintf(os.Stderr, "Total: %v\n", total)
+	return total
+}
+
+// printUnsent prints, for each source not completely sent, the lines that have been sent or
+// rejected, the lines read but not sent (still being sent or failed with a fatal error), and
+// the last line read. A rotated source is reported with the file that replaced it.
+func printUnsent(sources []*source) {
+	fmt.Fprintf(os.Stderr, "Lines not sent:\n")
+	for _, src := range sources {
+		for s := src; s != nil; s = s.next {
+			lines := trackers[s]
+			pending := lines.pendingLines()
+			if lines.ended && len(pending) == 0 {
+				continue
+			}
+			name := s.name
+			if s != src {
+				name += " (after rotation)"
+			}
+			msg := fmt.Sprintf("   %s: sent or rejected up to line %v", name, lines.contiguous())
+			if len(pending) > 0 {
+				msg += fmt.Sprintf(", not sent lines %s", formatLines(pending))
+			}
+			if !lines.ended {
+				msg += fmt.Sprintf(", not read after line %v", lines.lastRead)
+			}
+			fmt.Fprintln(os.Stderr, msg)
+		}
+	}
+}
+
+// printTargetFailures prints the failures of each target of the rows (see -tee), if any.
+func printTargetFailures() {
+	failures := tee.Failures()
+	var total uint64
+	for _, f := range failures {
+		total += f
+	}
+	if total == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Failures by target (-tee-mode %s):\n", teeMode)
+	for i, f := range failures {
+		fmt.Fprintf(os.Stderr, "   %s: %v\n", tee.Names[i], f)
+	}
+}
+
+// closeSender closes the sender, if it has files to close (see -out and -tee).
+// It returns false if they could not be written.
+func closeSender() bool {
+	c, ok := dataSender.(io.Closer)
+	if !ok {
+		return true
+	}
+	if err := c.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "An error occurred closing the output files (%s).\n", err)
+		return false
+	}
+	return true
+}
+
+// closeRejects closes the quarantine file, if any, and prints its summary.
+// It returns false if the file could not be written.
+func closeRejects() bool {
+	if rejects == nil {
+		return true
+	}
+	err := rejects.close()
+	fmt.Fprintln(os.Stderr, rejects.summary())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "An error occurred closing %s (%s).\n", rejectsFile, err)
+		return false
 	}
+	return true
 }
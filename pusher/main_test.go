@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goex/ltser/csvjson"
+	"goex/ltser/sender"
+	httpsender "goex/ltser/sender/http"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadSourceTruncatedGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	fmt.Fprintln(zw, "time,station,a")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(zw, "2019-01-01 00:%02d,B3,%v\n", i%60, rand.Int63())
+	}
+	zw.Close()
+	filename := filepath.Join(dir, "data.csv.gz")
+	ioutil.WriteFile(filename, buf.Bytes()[:buf.Len()/2], 0644)
+
+	sources, err := sourcesOf(filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chData := []chan dataMsg{make(chan dataMsg)}
+	chCtrl := make(chan controlMsg)
+	go func() {
+		for range chData[0] {
+		}
+	}()
+	var i uint
+	go func() {
+		readSource(sources[0], &i, chData, chCtrl)
+		close(chData[0])
+		close(chCtrl)
+	}()
+
+	var rows, fatal int
+	timeout := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case msg, ok := <-chCtrl:
+			switch {
+			case !ok:
+				done = true
+			case msg.isFatal:
+				fatal++
+				if msg.line != 0 {
+					t.Errorf("fatal error %v on line %v, want an error of the source", msg.err, msg.line)
+				}
+			case msg.err == nil:
+				rows++
+			case msg.err != errEndOfSource:
+				t.Errorf("error %v on line %v, want a fatal error of the source", msg.err, msg.line)
+			}
+		case <-timeout:
+			t.Fatalf("readSource() of a truncated gzip still reading after %v rows", rows)
+		}
+	}
+
+	if fatal != 1 || rows == 0 || rows >= 20000 {
+		t.Errorf("readSource() of a truncated gzip: %v rows and %v fatal errors, want some rows and 1 fatal error", rows, fatal)
+	}
+}
+
+func TestReadSourceRawLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "data.csv")
+	ioutil.WriteFile(filename, []byte("time,station,a\n"+
+		"2019-01-01 00:00,B3,1\n"+
+		"2019-01-01 00:01, B3 ,\"x\"y\n"+
+		"\"2019-01-01 00:02\",\"B3\",  2,extra\r\n"+
+		"\"2019-01-01 00:03\",\"B3\nC\",3\n"+
+		"\n"+
+		"2019-01-01 00:04,B3\n"), 0644)
+
+	defer func(h uint) { headersRows = h }(headersRows)
+	headersRows = 1
+	sources, err := sourcesOf(filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chData := []chan dataMsg{make(chan dataMsg, 10)}
+	chCtrl := make(chan controlMsg, 10)
+	var i uint
+	readSource(sources[0], &i, chData, chCtrl)
+
+	tests := []struct {
+		line uint
+		raw  string
+	}{
+		{3, "2019-01-01 00:01, B3 ,\"x\"y"},
+		{4, "\"2019-01-01 00:02\",\"B3\",  2,extra"},
+		{6, "2019-01-01 00:04,B3"},
+	}
+	var errs []controlMsg
+	for len(chCtrl) > 0 {
+		if msg := <-chCtrl; msg.err != nil && msg.err != errEndOfSource {
+			errs = append(errs, msg)
+		}
+	}
+	if len(errs) != len(tests) {
+		t.Fatalf("readSource() rejected %v rows, want %v", len(errs), len(tests))
+	}
+
+	var buf bytes.Buffer
+	for k, tt := range tests {
+		if errs[k].line != tt.line {
+			t.Errorf("rejected line %v, want %v", errs[k].line, tt.line)
+		}
+		buf.Reset()
+		w := &rejectsWriter{cw: csv.NewWriter(&buf), counts: make(map[task]uint)}
+		if err := w.write(errs[k]); err != nil {
+			t.Fatal(err)
+		}
+		w.cw.Flush()
+		r, err := csv.NewReader(&buf).Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw := r[len(r)-1]; raw != tt.raw {
+			t.Errorf("raw line %v = %q, want %q", tt.line, raw, tt.raw)
+		}
+	}
+}
+
+func TestLogMsgRejects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(r *rejectsWriter) { rejects = r }(rejects)
+	rejects, err = newRejectsWriter(filepath.Join(dir, "rejects.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := &source{name: "data.csv"}
+	for _, c := range []struct {
+		msg      controlMsg
+		rejected bool
+	}{
+		{controlMsg{err: errors.New("wrong number of fields"), origin: readerTask, src: src, line: 2, raw: "a,b"}, true},
+		{controlMsg{err: sender.Rejected(errors.New("invalid")), origin: senderTask, src: src, line: 3, raw: "c,d"}, true},
+		{controlMsg{err: errors.New("connection refused"), isFatal: true, origin: senderTask, src: src, line: 4}, false},
+		{controlMsg{err: errStopped, origin: senderTask, src: src, line: 5}, false},
+		{controlMsg{origin: senderTask, src: src, line: 6}, false},
+	} {
+		before := rejects.counts[c.msg.origin]
+		logMsg(c.msg)
+		if got := rejects.counts[c.msg.origin] > before; got != c.rejected {
+			t.Errorf("logMsg(%v on line %v, fatal %v) quarantined the row: %v, want %v", c.msg.err, c.msg.line, c.msg.isFatal, got, c.rejected)
+		}
+	}
+	if err := rejects.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "rejects.csv"))
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Errorf("rejects file %q, want headers and 2 rows", b)
+	}
+}
+
+func TestParseDialect(t *testing.T) {
+	for _, c := range []struct {
+		delimiter, encoding, decimal string
+		out                          csvjson.Dialect
+		ok                           bool
+	}{
+		{"", "", "", csvjson.Dialect{}, true},
+		{",", "", "", csvjson.Dialect{Comma: ','}, true},
+		{";", "latin1", ",", csvjson.Dialect{Comma: ';', Encoding: "latin1", Decimal: ','}, true},
+		{"tab", "", ".", csvjson.Dialect{Comma: '\t', Decimal: '.'}, true},
+		{`\t`, "", "", csvjson.Dialect{Comma: '\t'}, true},
+		{"|", "utf-8", "", csvjson.Dialect{Comma: '|', Encoding: "utf-8"}, true},
+		{"¦", "", "", csvjson.Dialect{Comma: '¦'}, true},
+		{";;", "", "", csvjson.Dialect{}, false},
+		{"", "", "'", csvjson.Dialect{}, false},
+		{"", "", ",.", csvjson.Dialect{}, false},
+	} {
+		got, err := parseDialect(c.delimiter, c.encoding, c.decimal)
+		if (err == nil) != c.ok {
+			t.Errorf("parseDialect(%q, %q, %q) returned error %v", c.delimiter, c.encoding, c.decimal, err)
+		}
+		if err == nil && got != c.out {
+			t.Errorf("parseDialect(%q, %q, %q) => %+v != %+v", c.delimiter, c.encoding, c.decimal, got, c.out)
+		}
+	}
+}
+
+func TestReadSourceKeyOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pusher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "time,station,a")
+	for i := 0; i < 60; i++ {
+		fmt.Fprintf(&b, "2019-01-01 00:%02d,B%v,%v\n", i, i%7, i)
+	}
+	filename := filepath.Join(dir, "data.csv")
+	ioutil.WriteFile(filename, b.Bytes(), 0644)
+
+	defer func(h uint, k string) { headersRows, keyColumn = h, k }(headersRows, keyColumn)
+	headersRows, keyColumn = 1, "station"
+	sources, err := sourcesOf(filename, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chData := []chan dataMsg{make(chan dataMsg, 60), make(chan dataMsg, 60), make(chan dataMsg, 60)}
+	chCtrl := make(chan controlMsg, 100)
+	var i uint
+	readSource(sources[0], &i, chData, chCtrl)
+
+	channels := make(map[string]int) // Of the stations.
+	lines := make(map[string]uint)   // Last line of the stations.
+	rows := 0
+	for c, ch := range chData {
+		for len(ch) > 0 {
+			msg := <-ch
+			rows++
+			var obj map[string]interface{}
+			if err := json.Unmarshal(msg.data, &obj); err != nil {
+				t.Fatal(err)
+			}
+			station := obj["station"].(string)
+			if prev, ok := channels[station]; ok && prev != c {
+				t.Errorf("rows of station %s sent to channels %v and %v, want a single channel", station, prev, c)
+			}
+			if msg.line <= lines[station] {
+				t.Errorf("line %v of station %s after line %v, want lines in order", msg.line, station, lines[station])
+			}
+			channels[station], lines[station] = c, msg.line
+		}
+	}
+	if rows != 60 || len(channels) != 7 {
+		t.Errorf("readSource() sent %v rows of %v stations, want 60 rows of 7 stations", rows, len(channels))
+	}
+}
+
+func TestTrack(t *testing.T) {
+	src := &source{name: "data.csv"}
+	defer func(tr map[*source]*tracker) { trackers = tr }(trackers)
+	trackers = map[*source]*tracker{src: newTracker(0)}
+
+	fail := errors.New("connection refused")
+	for _, c := range []struct {
+		msg            controlMsg
+		contiguous     uint
+		sent, rejected uint
+	}{
+		{controlMsg{origin: readerTask, src: src, line: 2}, 1, 0, 0},
+		{controlMsg{origin: readerTask, src: src, line: 3}, 1, 0, 0},
+		{controlMsg{err: fail, origin: readerTask, src: src, line: 4}, 1, 0, 1}, // Rejected when read.
+		{controlMsg{origin: readerTask, src: src, line: 5}, 1, 0, 1},
+		{controlMsg{origin: senderTask, src: src, line: 3}, 1, 1, 1},
+		{controlMsg{err: fail, isFatal: true, origin: senderTask, src: src, line: 2}, 1, 1, 1}, // Never acknowledged.
+		{controlMsg{err: sender.Rejected(fail), origin: senderTask, src: src, line: 5}, 1, 1, 2},
+		{controlMsg{err: errEndOfSource, origin: readerTask, src: src, line: 6}, 1, 1, 2},
+	} {
+		track(c.msg)
+		lines := trackers[src]
+		if got := lines.contiguous(); got != c.contiguous || src.stats.sent != c.sent || src.stats.rejected != c.rejected {
+			t.Errorf("track(%v on line %v, fatal %v) => contiguous %v, sent %v, rejected %v != %v, %v, %v", c.msg.err, c.msg.line,
+				c.msg.isFatal, got, src.stats.sent, src.stats.rejected, c.contiguous, c.sent, c.rejected)
+		}
+	}
+	if got := formatLines(trackers[src].pendingLines()); got != "2" {
+		t.Errorf("pending lines after a fatal error => %q != %q", got, "2")
+	}
+}
+
+func TestAuthOf(t *testing.T) {
+	for _, c := range []struct {
+		token, user, password, key string
+		out                        httpsender.Auth
+		ok                         bool
+	}{
+		{"", "", "", "", nil, true},
+		{"t0k3n", "", "", "", httpsender.Auths{httpsender.BearerAuth("t0k3n")}, true},
+		{"", "user", "secret", "", httpsender.Auths{httpsender.BasicAuth("user", "secret")}, true},
+		{"", "user", "", "", httpsender.Auths{httpsender.BasicAuth("user", "")}, true},
+		{"", "", "", "k3y", httpsender.Auths{httpsender.HMACAuth([]byte("k3y"))}, true},
+		{"t0k3n", "", "", "k3y", httpsender.Auths{httpsender.BearerAuth("t0k3n"), httpsender.HMACAuth([]byte("k3y"))}, true},
+		{"t0k3n", "user", "", "", nil, false},
+		{"t0k3n", "", "secret", "", nil, false},
+		{"", "", "secret", "", nil, false},
+	} {
+		got, err := authOf(c.token, c.user, c.password, c.key)
+		if (err == nil) != c.ok {
+			t.Errorf("authOf(%q, %q, %q, %q) returned error %v", c.token, c.user, c.password, c.key, err)
+		}
+		if !reflect.DeepEqual(got, c.out) {
+			t.Errorf("authOf(%q, %q, %q, %q) => %v != %v", c.token, c.user, c.password, c.key, got, c.out)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goex/ltser/csvjson"
+	"goex/ltser/sender"
+	multisender "goex/ltser/sender/multi"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	startTime   = time.Now()
+	bytesRead   int64 // Bytes read from the input files, accessed atomically.
+	errorCounts = make(map[string]uint)
+)
+
+// A countingReader counts the bytes read in bytesRead.
+type countingReader struct {
+	r io.Reader
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	atomic.AddInt64(&bytesRead, int64(n))
+	return n, err
+}
+
+// A retryCounter is a sender that counts its retries (e.g. the http sender).
+type retryCounter interface {
+	Retries() uint64
+}
+
+func retries() uint64 {
+	if rc, ok := dataSender.(retryCounter); ok {
+		return rc.Retries()
+	}
+	return 0
+}
+
+// spooled returns the number of requests spooled to the -dlq directory.
+func spooled() uint64 {
+	if sc, ok := dataSender.(interface{ Spooled() uint64 }); ok {
+		return sc.Spooled()
+	}
+	return 0
+}
+
+// errorCategory classifies the error of a control message by phase and cause, e.g.
+// "read: wrong number of fields" or "send: connection", for the error counts.
+func errorCategory(msg controlMsg) string {
+	err := msg.err
+	if we, ok := err.(interface{ WrappedErrors() []error }); ok { // E.g. the errors of the retries.
+		for _, e := range we.WrappedErrors() {
+			if e != nil {
+				err = e
+			}
+		}
+	}
+
+	var me *multisender.Error
+	if errors.As(err, &me) && len(me.Errors) > 0 { // The failure of the first target.
+		err = me.Errors[0].Err
+	}
+
+	var pe *csv.ParseError
+	var re *csvjson.RowError
+	var ue *url.Error
+	var ie *invalidRowError
+	var xe *sender.IndexError
+	cause := "other"
+	switch {
+	case errors.As(err, &pe):
+		cause = pe.Err.Error()
+	case errors.As(err, &re), errors.As(err, &ie):
+		cause = "invalid row"
+	case errors.As(err, &xe):
+		cause = "rejected"
+	case errors.Is(err, context.DeadlineExceeded):
+		cause = "timeout"
+	case errors.As(err, &ue):
+		cause = "connection"
+	case strings.HasPrefix(err.Error(), "response status"):
+		cause = err.Error()
+	case msg.line == 0:
+		cause = "file"
+	}
+	return phaseNames[msg.origin] + ": " + cause
+}
+
+// printProgress prints on a single line the rows processed (sent or rejected) and their rate,
+// the bytes read, the percent of the input and the time to completion, and the error counts.
+func printProgress(sources []*source) {
+	var processed uint
+	var size int64
+	for _, src := range sources {
+		for s := src; s != nil; s = s.next {
+			processed += s.stats.sent + s.stats.rejected
+		}
+		size += src.size
+	}
+	elapsed := time.Since(startTime)
+	read := atomic.LoadInt64(&bytesRead)
+
+	line := fmt.Sprintf("%v rows (%.1f rows/s), %s read", processed, float64(processed)/elapsed.Seconds(), formatBytes(read))
+	if size > 0 && !follow && read > 0 {
+		eta := time.Duration(float64(elapsed) * float64(size-read) / float64(read))
+		line += fmt.Sprintf(" of %s (%.0f%%), ETA %v", formatBytes(size), 100*float64(read)/float64(size), eta.Round(time.Second))
+	}
+	if len(errorCounts) > 0 {
+		line += ", errors: " + formatCounts(errorCounts)
+	}
+	fmt.Fprintf(os.Stderr, "\r%s\033[K", line) // Clear the rest of the previous line.
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%v B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatCounts(counts map[string]uint) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = fmt.Sprintf("%s %v", k, counts[k])
+	}
+	return strings.Join(keys, ", ")
+}
+
+// A runSummary is the machine readable summary of a run, written to the -summary file.
+type runSummary struct {
+	Files    []fileSummary   `json:"files"`
+	Rows     uint            `json:"rows"` // Rows read, excluding the skipped ones.
+	Filtered uint            `json:"filtered"`
+	Skipped  uint            `json:"skipped"`
+	Sent     uint            `json:"sent"`
+	Rejected uint            `json:"rejected"`
+	NotSent  uint            `json:"not_sent"` // Rows read but neither sent nor rejected (see -grace).
+	Retries  uint64          `json:"retries"`
+	Spooled  uint64          `json:"spooled"` // Requests spooled to the -dlq directory.
+	Targets  []targetSummary `json:"targets,omitempty"`
+	Errors   map[string]uint `json:"errors"` // Errors count by category (see errorCategory).
+	Bytes    int64           `json:"bytes"`
+	Started  time.Time       `json:"started"`
+	Duration float64         `json:"duration"` // Seconds.
+	ExitCode int             `json:"exit_code"`
+}
+
+type fileSummary struct {
+	Name     string `json:"name"`
+	Rows     uint   `json:"rows"`
+	Filtered uint   `json:"filtered"`
+	Skipped  uint   `json:"skipped"`
+	Sent     uint   `json:"sent"`
+	Rejected uint   `json:"rejected"`
+	NotSent  uint   `json:"not_sent"`
+}
+
+// A targetSummary is the summary of a target of the rows (see -tee).
+type targetSummary struct {
+	Name     string `json:"name"`
+	Failures uint64 `json:"failures"`
+}
+
+// targetSummaries returns the failures of each -tee target, nil without -tee.
+func targetSummaries() []targetSummary {
+	if tee == nil {
+		return nil
+	}
+	var targets []targetSummary
+	for i, f := range tee.Failures() {
+		targets = append(targets, targetSummary{Name: tee.Names[i], Failures: f})
+	}
+	return targets
+}
+
+// writeSummary writes the summary of the run as JSON.
+func writeSummary(filename string, sources []*source, code int) error {
+	sum := runSummary{
+		Files:    make([]fileSummary, 0, len(sources)),
+		Retries:  retries(),
+		Spooled:  spooled(),
+		Targets:  targetSummaries(),
+		Errors:   errorCounts,
+		Bytes:    atomic.LoadInt64(&bytesRead),
+		Started:  startTime,
+		Duration: time.Since(startTime).Seconds(),
+		ExitCode: code,
+	}
+	for _, src := range sources {
+		f := fileSummary{Name: src.name}
+		for s := src; s != nil; s = s.next {
+			f.Rows += s.stats.read
+			f.Filtered += s.stats.filtered
+			f.Skipped += s.stats.skipped
+			f.Sent += s.stats.sent
+			f.Rejected += s.stats.rejected
+			f.NotSent += uint(len(trackers[s].pending))
+		}
+		sum.Files = append(sum.Files, f)
+		sum.Rows += f.Rows
+		sum.Filtered += f.Filtered
+		sum.Skipped += f.Skipped
+		sum.Sent += f.Sent
+		sum.Rejected += f.Rejected
+		sum.NotSent += f.NotSent
+	}
+
+	b, err := json.MarshalIndent(sum, "", "   ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(b, '\n'), 0644)
+}
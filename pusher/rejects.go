@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var phaseNames = map[task]string{readerTask: "read", senderTask: "send"}
+
+// A rejectsWriter writes the rows that could not be read or sent to a quarantine file,
+// as csv (if the file has .csv extension) or as NDJSON.
+type rejectsWriter struct {
+	filename string
+	f        *os.File
+	cw       *csv.Writer
+	counts   map[task]uint
+}
+
+type rejectedRow struct {
+	File  string `json:"file"`
+	Line  uint   `json:"line"`
+	Phase string `json:"phase"`
+	Error string `json:"error"`
+	Raw   string `json:"raw"`
+}
+
+// newRejectsWriter creates the quarantine file.
+func newRejectsWriter(filename string) (*rejectsWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &rejectsWriter{filename: filename, f: f, counts: make(map[task]uint)}
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		w.cw = csv.NewWriter(f)
+		if err := w.cw.Write([]string{"file", "line", "phase", "error", "raw"}); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// write records the row of a control message carrying a rejection (a non fatal error).
+// The raw row is written as read from its source (see lineRecorder), without line terminator.
+func (w *rejectsWriter) write(msg controlMsg) error {
+	w.counts[msg.origin]++
+
+	raw := strings.Trim(msg.raw, "\r\n") // Empty lines before the row are skipped by the csv reader.
+
+	if w.cw != nil {
+		return w.cw.Write([]string{msg.src.name, strconv.FormatUint(uint64(msg.line), 10), phaseNames[msg.origin], msg.err.Error(), raw})
+	}
+
+	b, err := json.Marshal(rejectedRow{File: msg.src.name, Line: msg.line, Phase: phaseNames[msg.origin], Error: msg.err.Error(), Raw: raw})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w.f, "%s\n", b)
+	return err
+}
+
+// close flushes and closes the quarantine file.
+func (w *rejectsWriter) close() error {
+	if w.cw != nil {
+		w.cw.Flush()
+		if err := w.cw.Error(); err != nil {
+			w.f.Close()
+			return err
+		}
+	}
+	return w.f.Close()
+}
+
+// summary describes the rejected rows.
+func (w *rejectsWriter) summary() string {
+	total := w.counts[readerTask] + w.counts[senderTask]
+	return fmt.Sprintf("Rejected %v rows (read: %v, send: %v), written to %s.",
+		total, w.counts[readerTask], w.counts[senderTask], w.filename)
+}
+
+// A lineRecorder is the input of a csv reader, recording the raw lines it reads. It returns at most one line
+// by Read, so that the csv reader never reads past the lines of the record it is parsing: after each record,
+// the lines read since the previous take are the raw lines of the record, even if it could not be parsed.
+type lineRecorder struct {
+	r    *bufio.Reader
+	line []byte // Rest of the line being read.
+	err  error  // Of the line being read, returned after it.
+	raw  []byte
+}
+
+func newLineRecorder(r io.Reader) *lineRecorder {
+	return &lineRecorder{r: bufio.NewReader(r)}
+}
+
+func (lr *lineRecorder) Read(p []byte) (int, error) {
+	if len(lr.line) == 0 {
+		if lr.err != nil {
+			err := lr.err
+			lr.err = nil
+			return 0, err
+		}
+		line, err := lr.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull { // Long line: the rest is read by the next ReadSlice.
+			err = nil
+		}
+		lr.raw = append(lr.raw, line...)
+		lr.line = line // Valid up to the next ReadSlice, once it has been returned.
+		lr.err = err
+	}
+
+	n := copy(p, lr.line)
+	lr.line = lr.line[n:]
+	return n, nil
+}
+
+// take returns the lines read since the previous take.
+func (lr *lineRecorder) take() string {
+	raw := string(lr.raw)
+	lr.raw = lr.raw[:0]
+	return raw
+}
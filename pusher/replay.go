@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"goex/ltser/filter"
+	"goex/ltser/matschmazia/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A replayer paces the rows according to their timestamps, as if they were produced live.
+// The first row is sent at once, the following ones when, scaled by speed, as much time
+// has passed since the first one as between their timestamps. If rebase is true, timestamps
+// are rewritten to the time they are sent at (or, at max speed, shifted to start from now).
+type replayer struct {
+	column string
+	speed  float64 // Zero means max speed: no pacing.
+	rebase bool
+	first  time.Time // Timestamp of the first row.
+	start  time.Time // Time the first row was sent at.
+}
+
+// parseSpeed parses a replay speed like "1x", "60x" or "max" (zero).
+func parseSpeed(s string) (float64, error) {
+	if strings.ToLower(s) == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(s), "x"), 64)
+	if err != nil || speed <= 0 {
+		return 0, fmt.Errorf("invalid replay speed %q", s)
+	}
+	return speed, nil
+}
+
+// replay waits until the row is due and, if rebase is true, rewrites its timestamp.
+// Rows without a valid timestamp are not delayed nor rewritten. Waiting ends if the pusher is stopped.
+func (r *replayer) replay(obj map[string]interface{}) {
+	t, layout, ok := parseRowTime(fmt.Sprint(obj[r.column]))
+	if !ok {
+		return
+	}
+	if r.start.IsZero() {
+		r.first, r.start = t, time.Now()
+	}
+
+	elapsed := t.Sub(r.first)
+	due := r.start
+	if r.speed > 0 {
+		due = r.start.Add(time.Duration(float64(elapsed) / r.speed))
+		select {
+		case <-time.After(time.Until(due)):
+		case <-chStop:
+		}
+	} else {
+		due = r.start.Add(elapsed)
+	}
+
+	if r.rebase {
+		obj[r.column] = due.In(t.Location()).Format(layout)
+	}
+}
+
+// parseRowTime parses a timestamp in the format of the Matsch/Mazia data (see models.ParseTime)
+// or in one of the filter.TimeLayouts, and returns the layout used. Timestamps without time
+// zone are in models.TimeLocation.
+func parseRowTime(s string) (time.Time, string, bool) {
+	if t, err := models.ParseTime(s); err == nil {
+		return t, models.TimeLayout, true
+	}
+	for _, layout := range filter.TimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, models.TimeLocation); err == nil {
+			return t, layout, true
+		}
+	}
+	return time.Time{}, "", false
+}
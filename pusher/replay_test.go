@@ -0,0 +1,56 @@
+package main
+
+import (
+	"goex/ltser/matschmazia/models"
+	"testing"
+	"time"
+)
+
+func TestParseSpeed(t *testing.T) {
+	for _, c := range []struct {
+		in  string
+		out float64
+		ok  bool
+	}{
+		{"max", 0, true},
+		{"MAX", 0, true},
+		{"1", 1, true},
+		{"10x", 10, true},
+		{"0.5X", 0.5, true},
+		{"0", 0, false},
+		{"-2x", 0, false},
+		{"x", 0, false},
+		{"", 0, false},
+		{"fast", 0, false},
+	} {
+		got, err := parseSpeed(c.in)
+		if (err == nil) != c.ok {
+			t.Errorf("parseSpeed(%q) returned error %v", c.in, err)
+		}
+		if got != c.out {
+			t.Errorf("parseSpeed(%q) => %v != %v", c.in, got, c.out)
+		}
+	}
+}
+
+func TestParseRowTime(t *testing.T) {
+	for _, c := range []struct {
+		in     string
+		out    time.Time
+		layout string
+		ok     bool
+	}{
+		{"2019-03-04 12:30:15", time.Date(2019, 3, 4, 12, 30, 15, 0, models.TimeLocation), models.TimeLayout, true},
+		{"2019-03-04 12:30", time.Date(2019, 3, 4, 12, 30, 0, 0, models.TimeLocation), "2006-01-02 15:04", true},
+		{"2019-03-04", time.Date(2019, 3, 4, 0, 0, 0, 0, models.TimeLocation), "2006-01-02", true},
+		{"2019-03-04T12:30:15Z", time.Date(2019, 3, 4, 12, 30, 15, 0, time.UTC), time.RFC3339, true},
+		{"04/03/2019", time.Time{}, "", false},
+		{"", time.Time{}, "", false},
+		{"<nil>", time.Time{}, "", false},
+	} {
+		got, layout, ok := parseRowTime(c.in)
+		if ok != c.ok || !got.Equal(c.out) || layout != c.layout {
+			t.Errorf("parseRowTime(%q) => %v, %q, %v != %v, %q, %v", c.in, got, layout, ok, c.out, c.layout, c.ok)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Package batch provide an implementation of the sender interface that groups json objects
+// in batches, sent to another sender as a single JSON array or NDJSON body.
+package batch // import "goex/ltser/sender/batch"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"goex/ltser/sender"
+	"sync"
+	"time"
+)
+
+// Format is the format of the batches.
+type Format byte
+
+// Available formats.
+const (
+	JSONArray Format = iota // [{...},{...}]
+	NDJSON                  // One object per line.
+)
+
+const (
+	defMaxRows  = 100
+	defMaxBytes = 1 << 20
+	defLinger   = time.Second
+	defFormat   = JSONArray
+)
+
+// A Sender groups json objects in batches and sends them to a target sender.
+// A batch is sent when it has MaxRows objects, when adding an object would make
+// it larger than MaxBytes, or Linger after its first object has been added.
+//
+// If the target returns a *sender.BatchError, each object gets its own error
+// (a *sender.IndexError, with the index of the object in the batch, or nil);
+// otherwise all the objects of the batch get the error of the target.
+type Sender struct {
+	MaxRows  int
+	MaxBytes int
+	Linger   time.Duration
+	Format   Format
+	target   sender.Sender
+	mu       sync.Mutex
+	pending  *batch
+}
+
+// A batch is a group of objects added by Send and waiting for being sent.
+type batch struct {
+	rows [][]byte
+	size int
+	sent chan struct{} // Closed when the batch has been sent and errs set.
+	errs []error
+}
+
+// NewSender returns a new Sender of batches to target.
+func NewSender(target sender.Sender) *Sender {
+	batchSender := new(Sender)
+	batchSender.MaxRows = defMaxRows
+	batchSender.MaxBytes = defMaxBytes
+	batchSender.Linger = defLinger
+	batchSender.Format = defFormat
+	batchSender.target = target
+
+	return batchSender
+}
+
+// Send adds a json object to the current batch and waits for the batch to be sent.
+// It is safe for concurrent use: objects sent concurrently share the same batch.
+func (s *Sender) Send(b []byte) error {
+	var full []*batch // Batches to be sent by this call.
+
+	s.mu.Lock()
+	p := s.pending
+	if p != nil && p.size+len(b) > s.MaxBytes {
+		full = append(full, p)
+		p = nil
+	}
+	if p == nil {
+		p = &batch{sent: make(chan struct{})}
+		time.AfterFunc(s.Linger, func() { s.sendPending(p) })
+	}
+	i := len(p.rows)
+	p.rows = append(p.rows, b)
+	p.size += len(b) + 1 // Separator included.
+	s.pending = p
+	if len(p.rows) >= s.MaxRows {
+		full = append(full, p)
+		s.pending = nil
+	}
+	s.mu.Unlock()
+
+	for _, f := range full {
+		s.send(f)
+	}
+	<-p.sent
+	return p.errs[i]
+}
+
+// sendPending sends a batch, unless it has been sent already.
+func (s *Sender) sendPending(p *batch) {
+	s.mu.Lock()
+	if s.pending != p {
+		s.mu.Unlock()
+		return
+	}
+	s.pending = nil
+	s.mu.Unlock()
+
+	s.send(p)
+}
+
+func (s *Sender) send(p *batch) {
+	p.errs = s.SendBatch(p.rows)
+	close(p.sent)
+}
+
+// SendBatch sends json objects in as few batches as allowed by MaxRows and MaxBytes, and
+// returns the error of each object (nil if sent). Objects of the same batch keep their order.
+func (s *Sender) SendBatch(rows [][]byte) []error {
+	return s.SendBatchContext(context.Background(), rows)
+}
+
+// SendBatchContext is SendBatch, until the context is done: the batches not sent yet get
+// the error of the context. The context is passed to the target (see sender.WithContext).
+func (s *Sender) SendBatchContext(ctx context.Context, rows [][]byte) []error {
+	target := sender.WithContext(s.target)
+	errs := make([]error, len(rows))
+
+	for start := 0; start < len(rows); {
+		end, size := start+1, len(rows[start])
+		for end < len(rows) && end-start < s.MaxRows && size+1+len(rows[end]) <= s.MaxBytes {
+			size += 1 + len(rows[end])
+			end++
+		}
+
+		err := target.SendContext(ctx, s.encode(rows[start:end]))
+		if be, ok := err.(*sender.BatchError); ok {
+			for _, ie := range be.Errors {
+				if ie.Index >= 0 && ie.Index < end-start {
+					errs[start+ie.Index] = ie
+				}
+			}
+		} else {
+			for i := start; i < end; i++ {
+				errs[i] = err
+			}
+		}
+
+		start = end
+	}
+
+	return errs
+}
+
+// encode returns the body of a batch. NDJSON objects are compacted, to be on a single line.
+func (s *Sender) encode(rows [][]byte) []byte {
+	var buf bytes.Buffer
+
+	if s.Format == NDJSON {
+		for _, r := range rows {
+			if err := json.Compact(&buf, r); err != nil {
+				buf.Write(r) // Invalid json is left to the target to reject.
+			}
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes()
+	}
+
+	buf.WriteByte('[')
+	for i, r := range rows {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(r)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
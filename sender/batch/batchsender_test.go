@@ -0,0 +1,103 @@
+package batch_test
+
+import (
+	"errors"
+	"goex/ltser/sender"
+	"goex/ltser/sender/batch"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fakeTarget records the bodies sent and rejects the objects containing "bad".
+type fakeTarget struct {
+	mu     sync.Mutex
+	bodies []string
+	err    error
+}
+
+func (t *fakeTarget) Send(b []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bodies = append(t.bodies, string(b))
+	if t.err != nil {
+		return t.err
+	}
+
+	var be sender.BatchError
+	for i, obj := range strings.Split(strings.Trim(string(b), "[]\n"), "},{") {
+		if strings.Contains(obj, "bad") {
+			be.Errors = append(be.Errors, &sender.IndexError{Index: i, Err: "bad row"})
+		}
+	}
+	if len(be.Errors) > 0 {
+		return &be
+	}
+	return nil
+}
+
+func TestSendBatch(t *testing.T) {
+	rows := [][]byte{[]byte(`{"a":1}`), []byte(`{"a":"bad"}`), []byte(`{"a":3}`), []byte(`{"a":4}`), []byte(`{"a":5}`)}
+
+	var tests = []struct {
+		maxRows, maxBytes int
+		format            batch.Format
+		targetErr         error
+		wantBodies        []string
+		wantErrs          []bool
+	}{
+		{2, 1 << 20, batch.JSONArray, nil,
+			[]string{`[{"a":1},{"a":"bad"}]`, `[{"a":3},{"a":4}]`, `[{"a":5}]`},
+			[]bool{false, true, false, false, false}},
+		{10, 24, batch.JSONArray, nil,
+			[]string{`[{"a":1},{"a":"bad"}]`, `[{"a":3},{"a":4},{"a":5}]`},
+			[]bool{false, true, false, false, false}},
+		{3, 1 << 20, batch.NDJSON, errors.New("unreachable"),
+			[]string{"{\"a\":1}\n{\"a\":\"bad\"}\n{\"a\":3}\n", "{\"a\":4}\n{\"a\":5}\n"},
+			[]bool{true, true, true, true, true}},
+	}
+
+	for _, tt := range tests {
+		target := &fakeTarget{err: tt.targetErr}
+		s := batch.NewSender(target)
+		s.MaxRows, s.MaxBytes, s.Format = tt.maxRows, tt.maxBytes, tt.format
+
+		errs := s.SendBatch(rows)
+		if strings.Join(target.bodies, "|") != strings.Join(tt.wantBodies, "|") {
+			t.Errorf("SendBatch() bodies = %q, want %q", target.bodies, tt.wantBodies)
+		}
+		for i, err := range errs {
+			if (err != nil) != tt.wantErrs[i] {
+				t.Errorf("SendBatch() error of row %v = %v, want error %v", i, err, tt.wantErrs[i])
+			}
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	target := &fakeTarget{}
+	s := batch.NewSender(target)
+	s.MaxRows, s.Linger = 3, 50*time.Millisecond
+
+	rows := []string{`{"a":1}`, `{"a":"bad"}`, `{"a":3}`, `{"a":4}`}
+	errs := make([]error, len(rows))
+	var wg sync.WaitGroup
+	for i, r := range rows {
+		wg.Add(1)
+		go func(i int, r string) {
+			defer wg.Done()
+			errs[i] = s.Send([]byte(r))
+		}(i, r)
+	}
+	wg.Wait()
+
+	if len(target.bodies) != 2 {
+		t.Errorf("Send() bodies = %q, want a full batch and a lingered one", target.bodies)
+	}
+	for i, err := range errs {
+		if (err != nil) != (i == 1) {
+			t.Errorf("Send() error of %s = %v", rows[i], err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Package dlq provide an implementation of the sender interface that wraps another sender and
+// keeps the json objects it failed to send in an on-disk spool (dead-letter queue), to be replayed.
+package dlq // import "goex/ltser/sender/dlq"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goex/ltser/sender"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defCooldown     = 30 * time.Second
+	spoolExt        = ".dlq"
+	rejectedExt     = ".rejected"
+	tmpExt          = ".tmp"
+	spoolNameDigits = 12
+)
+
+// A Sender sends json objects to a target sender and, if the target fails, writes them to a spool
+// directory: Send succeeds once the object is safely on disk. Objects rejected by the target (see
+// sender.IsRejected and sender.BatchError) are not spooled, as they would be rejected again.
+//
+// After a failure, objects are spooled without trying the target for Cooldown, so that a target
+// that is down does not cost its whole retry policy to each object.
+//
+// Spooled objects are files named by a sequence number, e.g. "000000000001.dlq", sent in order by
+// Replay.
+type Sender struct {
+	spooled  uint64 // Accessed atomically: first field to be 64-bit aligned.
+	Cooldown time.Duration
+	dir      string
+	target   sender.Sender
+	mu       sync.Mutex
+	seq      int
+	failed   time.Time // Of the last failure of the target.
+}
+
+// NewSender returns a new Sender to target, with spool directory dir (created if needed).
+func NewSender(dir string, target sender.Sender) *Sender {
+	dlqSender := new(Sender)
+	dlqSender.Cooldown = defCooldown
+	dlqSender.dir = dir
+	dlqSender.target = target
+
+	return dlqSender
+}
+
+// Send sends a json object to the target, or spools it if the target fails.
+func (s *Sender) Send(b []byte) error {
+	return s.SendContext(context.Background(), b)
+}
+
+// SendContext is Send, with a context passed to the target (see sender.WithContext). If the
+// context is cancelled the object is not spooled: its error is returned, as it has not been
+// sent. If it is past its deadline, the object is spooled as for any failure of the target.
+func (s *Sender) SendContext(ctx context.Context, b []byte) error {
+	if s.coolingDown() {
+		return s.spool(b)
+	}
+
+	err := sender.WithContext(s.target).SendContext(ctx, b)
+	var be *sender.BatchError
+	if err == nil || sender.IsRejected(err) || errors.As(err, &be) || errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	s.mu.Lock()
+	s.failed = time.Now()
+	s.mu.Unlock()
+	if serr := s.spool(b); serr != nil {
+		return fmt.Errorf("%v, and spooling failed (%v)", err, serr)
+	}
+	return nil
+}
+
+// Spooled returns the number of objects spooled so far.
+func (s *Sender) Spooled() uint64 {
+	return atomic.LoadUint64(&s.spooled)
+}
+
+// Retries returns the retries of the target, if it counts them (e.g. the http sender), 0 otherwise.
+func (s *Sender) Retries() uint64 {
+	if rc, ok := s.target.(interface{ Retries() uint64 }); ok {
+		return rc.Retries()
+	}
+	return 0
+}
+
+// Close closes the target, if it has something to close.
+func (s *Sender) Close() error {
+	if c, ok := s.target.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Replay sends the spooled objects to the target in order, removing each one once sent. It stops
+// at the first failure, leaving the object in the spool. Objects rejected by the target are kept
+// with extension ".rejected", for inspection, and their errors are returned in rejected.
+func (s *Sender) Replay() (sent int, rejected []error, err error) {
+	names, err := s.fileNames(spoolExt)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, name := range names {
+		path := filepath.Join(s.dir, name)
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return sent, rejected, err
+		}
+
+		err = s.target.Send(b)
+		var be *sender.BatchError
+		switch {
+		case err == nil:
+			sent++
+			err = os.Remove(path)
+		case sender.IsRejected(err) || errors.As(err, &be):
+			rejected = append(rejected, fmt.Errorf("%s: %v", name, err))
+			err = os.Rename(path, strings.TrimSuffix(path, spoolExt)+rejectedExt)
+		default:
+			return sent, rejected, fmt.Errorf("%s: %v", name, err)
+		}
+		if err != nil {
+			return sent, rejected, err
+		}
+	}
+	return sent, rejected, nil
+}
+
+func (s *Sender) coolingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.failed.IsZero() && time.Since(s.failed) < s.Cooldown
+}
+
+// spool writes an object to the next file of the spool, atomically: the object is written
+// to a temporary file, flushed to disk and renamed.
+func (s *Sender) spool(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.seq == 0 {
+		if err := os.MkdirAll(s.dir, 0755); err != nil {
+			return err
+		}
+		names, err := s.fileNames(spoolExt, rejectedExt) // Rejected files keep their number.
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if seq, err := strconv.Atoi(strings.TrimSuffix(name, filepath.Ext(name))); err == nil && seq > s.seq {
+				s.seq = seq
+			}
+		}
+	}
+	s.seq++
+
+	path := filepath.Join(s.dir, fmt.Sprintf("%0*d%s", spoolNameDigits, s.seq, spoolExt))
+	f, err := os.Create(path + tmpExt)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+tmpExt, path)
+	}
+	if err != nil {
+		os.Remove(path + tmpExt)
+		return err
+	}
+
+	atomic.AddUint64(&s.spooled, 1)
+	return nil
+}
+
+// fileNames returns the names of the files of the spool with the given extensions, in order.
+// A missing directory is an empty spool.
+func (s *Sender) fileNames(exts ...string) ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, fi := range files {
+		for _, ext := range exts {
+			if filepath.Ext(fi.Name()) == ext {
+				names = append(names, fi.Name())
+			}
+		}
+	}
+	sort.Strings(names) // Fixed width sequence numbers.
+	return names, nil
+}
@@ -0,0 +1,80 @@
+package dlq_test
+
+import (
+	"errors"
+	"goex/ltser/sender"
+	dlqsender "goex/ltser/sender/dlq"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// A fakeSender fails or rejects the objects it is told to, and records the others.
+type fakeSender struct {
+	fail   map[string]bool
+	reject map[string]bool
+	sent   []string
+}
+
+func (f *fakeSender) Send(b []byte) error {
+	switch {
+	case f.fail[string(b)]:
+		return errors.New("unavailable")
+	case f.reject[string(b)]:
+		return sender.Rejected(errors.New("invalid"))
+	}
+	f.sent = append(f.sent, string(b))
+	return nil
+}
+
+func TestSendAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dlq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := &fakeSender{fail: map[string]bool{"2": true, "4": true}, reject: map[string]bool{"3": true}}
+	s := dlqsender.NewSender(dir, target)
+	s.Cooldown = 0
+
+	var tests = []struct {
+		obj          string
+		wantRejected bool
+	}{
+		{"1", false},
+		{"2", false}, // Spooled.
+		{"3", true},
+		{"4", false}, // Spooled.
+		{"5", false},
+	}
+	for _, tt := range tests {
+		if err := s.Send([]byte(tt.obj)); (err != nil) != tt.wantRejected || sender.IsRejected(err) != tt.wantRejected {
+			t.Errorf("Send(%q) = %v, want rejected %v", tt.obj, err, tt.wantRejected)
+		}
+	}
+	if s.Spooled() != 2 {
+		t.Errorf("Spooled() = %v, want 2", s.Spooled())
+	}
+
+	// The target is still failing "4": the replay stops there.
+	target.fail["2"] = false
+	sent, rejected, err := dlqsender.NewSender(dir, target).Replay()
+	if sent != 1 || len(rejected) != 0 || err == nil {
+		t.Errorf("Replay() = %v, %v, %v, want 1 sent and an error", sent, rejected, err)
+	}
+
+	target.fail["4"] = false
+	sent, rejected, err = dlqsender.NewSender(dir, target).Replay()
+	if sent != 1 || len(rejected) != 0 || err != nil {
+		t.Errorf("Replay() = %v, %v, %v, want 1 sent", sent, rejected, err)
+	}
+
+	if want := []string{"1", "5", "2", "4"}; !reflect.DeepEqual(target.sent, want) {
+		t.Errorf("sent %q, want %q", target.sent, want)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%v files left in the spool, want none", len(files))
+	}
+}
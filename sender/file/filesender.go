@@ -0,0 +1,242 @@
+// Package file provide an implementation of the sender interface to write json data to NDJSON files,
+// rotated by size or age.
+package file // import "goex/ltser/sender/file"
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy is when written data are flushed to disk (fsync).
+type SyncPolicy byte
+
+// Available sync policies.
+const (
+	SyncNone     SyncPolicy = iota // Left to the operating system.
+	SyncOnRotate                   // When a file is closed.
+	SyncInterval                   // When a file is closed, and by a Send after SyncEvery since the last sync.
+	SyncAlways                     // By each Send.
+)
+
+const (
+	defMaxBytes  = 0
+	defMaxAge    = 0
+	defSync      = SyncOnRotate
+	defSyncEvery = time.Second
+	seqDigits    = 6
+)
+
+// A Sender writes json objects to NDJSON files, one object per line.
+//
+// Files are named after the path given to NewSender, with a sequence number before the extension:
+// "data.ndjson" gives "data-000001.ndjson", "data-000002.ndjson" and so on. The sequence goes on
+// from the highest one already in the directory, so that files are never overwritten.
+//
+// A file is closed and the next one is opened (rotation) before a write would make it larger than
+// MaxBytes, or when it is older than MaxAge (zero means no limit). MaxAge is checked by Send: a file
+// is rotated by the first Send after it expires, and stays open until then (or until Close). With Gzip,
+// closed files are compressed, and ".gz" is added to their name.
+//
+// A write that fails does not leave a partial line: the file is truncated back to its last line.
+type Sender struct {
+	MaxBytes  int64
+	MaxAge    time.Duration
+	Gzip      bool
+	Sync      SyncPolicy
+	SyncEvery time.Duration
+	base      string // Path without extension.
+	ext       string
+	mu        sync.Mutex
+	f         *os.File
+	seq       int
+	size      int64
+	opened    time.Time
+	synced    time.Time
+}
+
+// NewSender returns a new Sender to files named after path.
+func NewSender(path string) *Sender {
+	fileSender := new(Sender)
+	fileSender.MaxBytes = defMaxBytes
+	fileSender.MaxAge = defMaxAge
+	fileSender.Sync = defSync
+	fileSender.SyncEvery = defSyncEvery
+	fileSender.ext = filepath.Ext(path)
+	fileSender.base = strings.TrimSuffix(path, fileSender.ext)
+
+	return fileSender
+}
+
+// ParseSyncPolicy returns the sync policy by name: "none", "rotate", "always", or a duration
+// (e.g. "1s") for SyncInterval with that interval.
+func ParseSyncPolicy(s string) (SyncPolicy, time.Duration, error) {
+	switch s {
+	case "none":
+		return SyncNone, 0, nil
+	case "rotate":
+		return SyncOnRotate, 0, nil
+	case "always":
+		return SyncAlways, 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, 0, fmt.Errorf("unknown sync policy %q", s)
+	}
+	return SyncInterval, d, nil
+}
+
+// Send writes a json object as a line of the current file. It is safe for concurrent use.
+func (s *Sender) Send(b []byte) error {
+	b = bytes.TrimRight(b, "\r\n")
+	line := make([]byte, len(b)+1)
+	copy(line, b)
+	line[len(b)] = '\n'
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f != nil && s.size > 0 &&
+		((s.MaxBytes > 0 && s.size+int64(len(line)) > s.MaxBytes) || (s.MaxAge > 0 && time.Since(s.opened) >= s.MaxAge)) {
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+	}
+	if s.f == nil {
+		if err := s.openFile(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.f.Write(line); err != nil {
+		s.discard()
+		return err
+	}
+	s.size += int64(len(line))
+
+	if s.Sync == SyncAlways || (s.Sync == SyncInterval && time.Since(s.synced) >= s.SyncEvery) {
+		s.synced = time.Now()
+		return s.f.Sync()
+	}
+	return nil
+}
+
+// Close closes (and compresses, with Gzip) the current file.
+func (s *Sender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	return s.closeFile()
+}
+
+// Name returns the name of the file with sequence number seq, before compression.
+func (s *Sender) Name(seq int) string {
+	return fmt.Sprintf("%s-%0*d%s", s.base, seqDigits, seq, s.ext)
+}
+
+func (s *Sender) openFile() error {
+	if s.seq == 0 {
+		last, err := s.lastSeq()
+		if err != nil {
+			return err
+		}
+		s.seq = last
+	}
+	s.seq++
+
+	f, err := os.OpenFile(s.Name(s.seq), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	s.f, s.size, s.opened, s.synced = f, 0, time.Now(), time.Now()
+	return nil
+}
+
+// discard removes what a failed write may have written after the last complete line, truncating the
+// file back to its size before the write. If that fails too, the file is closed (and the next one opened).
+func (s *Sender) discard() {
+	err := s.f.Truncate(s.size)
+	if err == nil {
+		_, err = s.f.Seek(s.size, io.SeekStart)
+	}
+	if err != nil {
+		s.closeFile()
+	}
+}
+
+func (s *Sender) closeFile() error {
+	f := s.f
+	s.f = nil
+
+	var err error
+	if s.Sync != SyncNone {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && s.Gzip {
+		err = s.compress(f.Name())
+	}
+	return err
+}
+
+// compress replaces a file with its gzip compressed copy.
+func (s *Sender) compress(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(name)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil && s.Sync != SyncNone {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz") // The original file is kept.
+		return err
+	}
+
+	return os.Remove(name)
+}
+
+// lastSeq returns the highest sequence number of the files already in the directory, 0 if none.
+func (s *Sender) lastSeq() (int, error) {
+	matches, err := filepath.Glob(s.base + "-*" + s.ext + "*")
+	if err != nil {
+		return 0, err
+	}
+
+	last := 0
+	for _, m := range matches {
+		m = strings.TrimSuffix(strings.TrimSuffix(m, ".gz"), s.ext)
+		seq, err := strconv.Atoi(strings.TrimPrefix(m, s.base+"-"))
+		if err == nil && seq > last {
+			last = seq
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,48 @@
+package file_test
+
+import (
+	filesender "goex/ltser/sender/file"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// TestSendWriteError makes a write fail half way by limiting the size of the files of the process.
+func TestSendWriteError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Skip(err)
+	}
+	defer syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit)
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: 30, Max: limit.Max}); err != nil {
+		t.Skip(err)
+	}
+
+	s := filesender.NewSender(filepath.Join(dir, "data.ndjson"))
+	for i, r := range []string{`{"a":"1111"}`, `{"a":"2222"}`, `{"a":"3333"}`} {
+		if err := s.Send([]byte(r)); (err != nil) != (i == 2) {
+			t.Fatalf("Send(%s) returned error %v", r, err)
+		}
+	}
+	syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit)
+	if err := s.Send([]byte(`{"a":"4444"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"data-000001.ndjson": "{\"a\":\"1111\"}\n{\"a\":\"2222\"}\n{\"a\":\"4444\"}\n"}
+	if got := readDir(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("Send() after a failed write: files %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package file_test
+
+import (
+	"compress/gzip"
+	filesender "goex/ltser/sender/file"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSendRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rows := []string{`{"a":"1"}`, `{"a":"2"}`, `{"a":"3"}`, "{\"a\":\"4\"}\n"}
+	var tests = []struct {
+		maxBytes int64
+		gzip     bool
+		want     map[string]string // Content of each file, by name.
+	}{
+		{0, false, map[string]string{"data-000001.ndjson": "{\"a\":\"1\"}\n{\"a\":\"2\"}\n{\"a\":\"3\"}\n{\"a\":\"4\"}\n"}},
+		{20, false, map[string]string{
+			"data-000001.ndjson": "{\"a\":\"1\"}\n{\"a\":\"2\"}\n",
+			"data-000002.ndjson": "{\"a\":\"3\"}\n{\"a\":\"4\"}\n"}},
+		{5, true, map[string]string{
+			"data-000001.ndjson.gz": "{\"a\":\"1\"}\n",
+			"data-000002.ndjson.gz": "{\"a\":\"2\"}\n",
+			"data-000003.ndjson.gz": "{\"a\":\"3\"}\n",
+			"data-000004.ndjson.gz": "{\"a\":\"4\"}\n"}},
+	}
+
+	for _, tt := range tests {
+		out, err := ioutil.TempDir(dir, "out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := filesender.NewSender(filepath.Join(out, "data.ndjson"))
+		s.MaxBytes, s.Gzip = tt.maxBytes, tt.gzip
+		for _, r := range rows {
+			if err := s.Send([]byte(r)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		got := readDir(t, out)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Send() with MaxBytes %v and Gzip %v: files %q, want %q", tt.maxBytes, tt.gzip, got, tt.want)
+		}
+	}
+}
+
+func TestSendContinuesSequence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "data-000007.ndjson.gz"), nil, 0644)
+
+	s := filesender.NewSender(filepath.Join(dir, "data.ndjson"))
+	s.MaxAge = time.Nanosecond
+	s.Send([]byte(`{}`))
+	s.Send([]byte(`{}`))
+	s.Close()
+
+	for _, name := range []string{"data-000008.ndjson", "data-000009.ndjson"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Send() after data-000007.ndjson.gz: %v", err)
+		}
+	}
+}
+
+func readDir(t *testing.T, dir string) map[string]string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := make(map[string]string)
+	for _, fi := range files {
+		f, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b []byte
+		if filepath.Ext(fi.Name()) == ".gz" {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err = ioutil.ReadAll(zr)
+		} else {
+			b, err = ioutil.ReadAll(f)
+		}
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content[fi.Name()] = string(b)
+	}
+	return content
+}
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Default client options.
+const (
+	defTimeout               = 30 * time.Second
+	defDialTimeout           = 10 * time.Second
+	defTLSHandshakeTimeout   = 10 * time.Second
+	defResponseHeaderTimeout = 0
+	defIdleConnTimeout       = 90 * time.Second
+	defMaxIdleConns          = 100
+	defMaxIdleConnsPerHost   = 32
+	defMaxConnsPerHost       = 0
+)
+
+// ClientOptions configure the http.Client of a Sender. Zero durations and limits mean none.
+type ClientOptions struct {
+	Timeout               time.Duration // Of a whole request, response body included.
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration // After the request has been written.
+	IdleConnTimeout       time.Duration // Of the keep-alive connections in the pool.
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	CAFile                string // PEM bundle of the trusted CAs, instead of the system ones.
+	CertFile              string // PEM client certificate, for mutual TLS (with KeyFile).
+	KeyFile               string
+	ServerName            string // Expected in the server certificate, if not the host of the URL.
+	Proxy                 string // Proxy URL. If empty, from the environment (HTTP_PROXY, HTTPS_PROXY and NO_PROXY); "direct" means none.
+}
+
+// DefaultClientOptions returns the options of the client of a new Sender.
+func DefaultClientOptions() ClientOptions {
+	return ClientOptions{
+		Timeout:               defTimeout,
+		DialTimeout:           defDialTimeout,
+		TLSHandshakeTimeout:   defTLSHandshakeTimeout,
+		ResponseHeaderTimeout: defResponseHeaderTimeout,
+		IdleConnTimeout:       defIdleConnTimeout,
+		MaxIdleConns:          defMaxIdleConns,
+		MaxIdleConnsPerHost:   defMaxIdleConnsPerHost,
+		MaxConnsPerHost:       defMaxConnsPerHost,
+	}
+}
+
+// NewClient returns a new http.Client with the given options.
+func NewClient(o ClientOptions) (*http.Client, error) {
+	tlsConfig := &tls.Config{ServerName: o.ServerName}
+
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %q", o.CAFile)
+		}
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	switch o.Proxy {
+	case "":
+	case "direct":
+		proxy = nil
+	default:
+		u, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           (&net.Dialer{Timeout: o.DialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   o.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: o.ResponseHeaderTimeout,
+		IdleConnTimeout:       o.IdleConnTimeout,
+		MaxIdleConns:          o.MaxIdleConns,
+		MaxIdleConnsPerHost:   o.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       o.MaxConnsPerHost,
+		ForceAttemptHTTP2:     true,
+	}
+
+	return &http.Client{Transport: transport, Timeout: o.Timeout}, nil
+}
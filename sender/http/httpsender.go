@@ -3,21 +3,76 @@ package http // import "goex/ltser/sender/http"
 
 import (
 	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"goex/ltser/sender"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 
 	"github.com/avast/retry-go"
 )
 
+// Default retry policy.
+const (
+	defMaxAttempts  = 10
+	defInitialDelay = 100 * time.Millisecond
+	defMaxDelay     = 30 * time.Second
+	defMaxJitter    = 100 * time.Millisecond
+	defMaxElapsed   = 2 * time.Minute
+)
+
 // A Sender send json objects to HTTP RESTFul API.
+//
+// Failed POSTs are retried on network errors and on 5xx, 408 and 429 responses, up to MaxAttempts
+// attempts and MaxElapsed time since the first one (zero means no limit). Delays between attempts
+// start from InitialDelay and double at each retry, plus a random jitter up to MaxJitter, up to
+// MaxDelay. The Retry-After header of a response, if any, takes precedence, even over MaxDelay:
+// if it ends after MaxElapsed since the first attempt, the POST is not retried.
+//
+// Responses 400, 413, 415 and 422 mean that the objects sent are invalid: the error is marked as
+// a rejection (see sender.IsRejected) and not retried, as are the other 4xx responses.
+//
+// If Auth is not nil, it adds credentials to each request (see BearerAuth, BasicAuth and HMACAuth).
+// Requests are done by Client, with DefaultClientOptions unless replaced (see NewClient).
 type Sender struct {
-	targetURL string
+	retries      uint64 // Accessed atomically: first field to be 64-bit aligned.
+	MaxAttempts  uint
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	MaxJitter    time.Duration
+	MaxElapsed   time.Duration
+	Auth         Auth
+	Client       *http.Client
+	targetURL    string
+}
+
+// A statusError is returned for responses with unexpected status.
+type statusError struct {
+	code       int
+	status     string
+	retryAfter time.Duration // Zero if not given.
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("response status %q", e.status)
 }
 
 // NewSender returns a new Sender to a given HTTP url.
 func NewSender(url string) *Sender {
 	httpSender := new(Sender)
+	httpSender.MaxAttempts = defMaxAttempts
+	httpSender.InitialDelay = defInitialDelay
+	httpSender.MaxDelay = defMaxDelay
+	httpSender.MaxJitter = defMaxJitter
+	httpSender.MaxElapsed = defMaxElapsed
+	httpSender.Client, _ = NewClient(DefaultClientOptions()) // No files to load: no errors.
 	httpSender.targetURL = url
 
 	return httpSender
@@ -25,7 +80,21 @@ func NewSender(url string) *Sender {
 
 // TrySend POST json objects to target url.
 func (s *Sender) TrySend(b []byte) error {
-	r, err := http.Post(s.targetURL, "application/json", bytes.NewBuffer(b))
+	return s.TrySendContext(context.Background(), b)
+}
+
+// TrySendContext POST json objects to target url. The request is cancelled when the context is done.
+func (s *Sender) TrySendContext(ctx context.Context, b []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.targetURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Auth != nil {
+		s.Auth.Authorize(req, b)
+	}
+
+	r, err := s.Client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -33,21 +102,142 @@ func (s *Sender) TrySend(b []byte) error {
 
 	// TODO: Not safe implementation.
 	// See: https://haisum.github.io/2017/09/11/golang-ioutil-readall/
-	_, err = ioutil.ReadAll(r.Body)
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return err
 	}
-	if r.StatusCode != http.StatusOK {
-		return fmt.Errorf("response status %q", r.Status)
+	if r.StatusCode == http.StatusMultiStatus || r.StatusCode == http.StatusBadRequest {
+		be := new(sender.BatchError)
+		if json.Unmarshal(body, be) == nil && len(be.Errors) > 0 {
+			return be
+		}
+	}
+	if r.StatusCode == http.StatusOK || r.StatusCode == http.StatusMultiStatus {
+		return nil
 	}
 
-	return nil
+	se := &statusError{code: r.StatusCode, status: r.Status, retryAfter: retryAfter(r.Header.Get("Retry-After"))}
+	switch r.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+		return sender.Rejected(se)
+	}
+	return se
 }
 
-// Send POST json objects to target url. It retries POST in case of failure.
+// Send POST json objects to target url. It retries POST in case of failure, as
+// allowed by the retry policy. The error returned is the one of the last attempt.
 func (s *Sender) Send(b []byte) error {
+	return s.SendContext(context.Background(), b)
+}
+
+// SendContext is Send, until the context is done: the current request is cancelled,
+// no more retries are done, and the error of the context is returned.
+func (s *Sender) SendContext(ctx context.Context, b []byte) error {
+	start := time.Now()
+	var lastErr error
+
+	attempts := 0
 	sendFunc := func() error {
-		return s.TrySend(b)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if attempts++; attempts > 1 {
+			atomic.AddUint64(&s.retries, 1)
+		}
+		lastErr = s.TrySendContext(ctx, b)
+		return lastErr
+	}
+	retryIf := func(err error) bool {
+		return ctx.Err() == nil && retryable(err) && (s.MaxElapsed == 0 || time.Since(start)+retryAfterOf(err) < s.MaxElapsed)
+	}
+	delay := func(n uint, _ *retry.Config) time.Duration {
+		// Waited here, rather than by retry.Do, so that the wait ends with the context.
+		t := time.NewTimer(s.delay(n, lastErr))
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+		}
+		return 0
+	}
+
+	maxAttempts := s.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	err := retry.Do(sendFunc,
+		retry.Attempts(maxAttempts),
+		retry.RetryIf(retryIf),
+		retry.DelayType(delay),
+		retry.LastErrorOnly(true))
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Retries returns the number of POST retries done so far.
+func (s *Sender) Retries() uint64 {
+	return atomic.LoadUint64(&s.retries)
+}
+
+// delay returns the delay after the attempt n (from 0) failed with err.
+func (s *Sender) delay(n uint, err error) time.Duration {
+	if ra := retryAfterOf(err); ra > 0 {
+		return ra // Not limited by MaxDelay: retrying earlier would be refused again.
+	}
+
+	d := s.InitialDelay
+	for i := uint(0); i < n && i < 32 && (s.MaxDelay == 0 || d < s.MaxDelay); i++ {
+		d *= 2
+	}
+	if s.MaxJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(s.MaxJitter)))
+	}
+	if s.MaxDelay > 0 && d > s.MaxDelay {
+		d = s.MaxDelay
+	}
+	return d
+}
+
+// retryAfterOf returns the Retry-After delay of the response that failed with err, zero if none.
+func retryAfterOf(err error) time.Duration {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.retryAfter
+	}
+	return 0
+}
+
+// retryable reports whether a POST failed with err can be retried: network errors, except for
+// invalid certificates, and 5xx, 408 (Request Timeout) and 429 (Too Many Requests) responses.
+func retryable(err error) bool {
+	var se *statusError
+	var be *sender.BatchError
+	var uae x509.UnknownAuthorityError
+	var cie x509.CertificateInvalidError
+	var he x509.HostnameError
+	switch {
+	case sender.IsRejected(err), errors.As(err, &be):
+		return false
+	case errors.As(err, &uae), errors.As(err, &cie), errors.As(err, &he):
+		return false
+	case errors.As(err, &se):
+		return se.code >= 500 || se.code == http.StatusRequestTimeout || se.code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// retryAfter parses a Retry-After header: seconds or HTTP date. It returns zero if missing or invalid.
+func retryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t)
 	}
-	return retry.Do(sendFunc)
+	return 0
 }
@@ -0,0 +1,212 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"goex/ltser/sender"
+	httpsender "goex/ltser/sender/http"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSendRetryPolicy(t *testing.T) {
+	var tests = []struct {
+		statuses     []int // Status of each attempt, the last one repeated.
+		wantAttempts int
+		wantErr      bool
+		wantRejected bool
+	}{
+		{[]int{200}, 1, false, false},
+		{[]int{503, 500, 200}, 3, false, false},
+		{[]int{429, 200}, 2, false, false},
+		{[]int{503}, 4, true, false},
+		{[]int{400}, 1, true, true},
+		{[]int{422}, 1, true, true},
+		{[]int{401}, 1, true, false},
+	}
+
+	for _, tt := range tests {
+		attempts := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status := tt.statuses[len(tt.statuses)-1]
+			if attempts < len(tt.statuses) {
+				status = tt.statuses[attempts]
+			}
+			attempts++
+			w.WriteHeader(status)
+		}))
+
+		s := httpsender.NewSender(ts.URL)
+		s.MaxAttempts, s.InitialDelay, s.MaxJitter = 4, time.Millisecond, 0
+		err := s.Send([]byte(`{}`))
+		ts.Close()
+
+		if attempts != tt.wantAttempts || (err != nil) != tt.wantErr || sender.IsRejected(err) != tt.wantRejected {
+			t.Errorf("Send() with statuses %v: attempts %v, error %v (rejected %v), want attempts %v, error %v (rejected %v)",
+				tt.statuses, attempts, err, sender.IsRejected(err), tt.wantAttempts, tt.wantErr, tt.wantRejected)
+		}
+		if want := uint64(attempts - 1); s.Retries() != want {
+			t.Errorf("Retries() = %v, want %v", s.Retries(), want)
+		}
+	}
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	var tests = []struct {
+		maxElapsed   time.Duration
+		wantAttempts int
+		wantErr      bool
+	}{
+		{0, 2, false},
+		{time.Minute, 2, false},
+		{500 * time.Millisecond, 1, true}, // Retry-After ends after MaxElapsed.
+	}
+
+	for _, tt := range tests {
+		attempts := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempts++; attempts == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+
+		s := httpsender.NewSender(ts.URL)
+		s.MaxDelay, s.MaxElapsed = 10*time.Millisecond, tt.maxElapsed
+		start := time.Now()
+		err := s.Send([]byte(`{}`))
+		elapsed := time.Since(start)
+		ts.Close()
+
+		if attempts != tt.wantAttempts || (err != nil) != tt.wantErr {
+			t.Errorf("Send() with MaxElapsed %v: attempts %v, error %v, want attempts %v, error %v",
+				tt.maxElapsed, attempts, err, tt.wantAttempts, tt.wantErr)
+		}
+		if attempts == 2 && elapsed < time.Second {
+			t.Errorf("Send() with MaxElapsed %v retried after %v, want the Retry-After of 1s, over the MaxDelay of 10ms", tt.maxElapsed, elapsed)
+		}
+	}
+}
+
+func TestAuth(t *testing.T) {
+	key := []byte("k3y")
+	var tests = []struct {
+		auth httpsender.Auth
+		want string // Authorization header.
+	}{
+		{httpsender.BearerAuth("t0ken"), "Bearer t0ken"},
+		{httpsender.BasicAuth("user", "pass"), "Basic dXNlcjpwYXNz"},
+		{httpsender.HMACAuth(key), ""},
+		{httpsender.Auths{httpsender.BasicAuth("user", "pass"), httpsender.HMACAuth(key)}, "Basic dXNlcjpwYXNz"},
+	}
+
+	body := []byte(`{"station":"B3"}`)
+	for _, tt := range tests {
+		var got string
+		var verifyErr error
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Get("Authorization")
+			b, _ := ioutil.ReadAll(r.Body)
+			if r.Header.Get(httpsender.SignatureHeader) != "" {
+				verifyErr = httpsender.VerifySignature(r.Header, b, key, time.Minute)
+				if httpsender.VerifySignature(r.Header, bytes.ToUpper(b), key, time.Minute) == nil {
+					t.Errorf("VerifySignature() of a tampered body: no error")
+				}
+			}
+		}))
+
+		s := httpsender.NewSender(ts.URL)
+		s.Auth = tt.auth
+		err := s.Send(body)
+		ts.Close()
+
+		if err != nil || got != tt.want || verifyErr != nil {
+			t.Errorf("Send() with %T: error %v, Authorization %q, signature error %v, want Authorization %q",
+				tt.auth, err, got, verifyErr, tt.want)
+		}
+	}
+}
+
+func TestNewClientCA(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600)
+	empty := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(empty, nil, 0600)
+
+	var tests = []struct {
+		caFile  string
+		wantErr bool
+	}{
+		{"", true}, // Self-signed certificate not trusted by the system.
+		{ca, false},
+	}
+
+	for _, tt := range tests {
+		opts := httpsender.DefaultClientOptions()
+		opts.CAFile = tt.caFile
+		client, err := httpsender.NewClient(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := httpsender.NewSender(ts.URL)
+		s.Client = client
+		if err := s.Send([]byte(`{}`)); (err != nil) != tt.wantErr {
+			t.Errorf("Send() with CA file %q: error %v, want error %v", tt.caFile, err, tt.wantErr)
+		}
+	}
+
+	opts := httpsender.DefaultClientOptions()
+	opts.CAFile = empty
+	if _, err := httpsender.NewClient(opts); err == nil {
+		t.Errorf("NewClient() with empty CA file: no error")
+	}
+}
+
+func TestSendContext(t *testing.T) {
+	var tests = []struct {
+		status int           // Of the responses.
+		wait   time.Duration // Before each response.
+	}{
+		{http.StatusOK, time.Minute},       // Request in flight.
+		{http.StatusServiceUnavailable, 0}, // Waiting to retry.
+	}
+
+	for _, tt := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ioutil.ReadAll(r.Body) // So that the server watches the connection, cancelling r.Context() when closed.
+			select {
+			case <-time.After(tt.wait):
+			case <-r.Context().Done():
+			}
+			w.WriteHeader(tt.status)
+		}))
+
+		s := httpsender.NewSender(ts.URL)
+		s.InitialDelay = time.Minute
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err := s.SendContext(ctx, []byte(`{}`))
+		cancel()
+		ts.Close()
+
+		if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+			t.Errorf("SendContext() with status %v after %v = %v after %v, want %v at once",
+				tt.status, tt.wait, err, time.Since(start), context.DeadlineExceeded)
+		}
+	}
+}
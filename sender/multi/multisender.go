@@ -0,0 +1,252 @@
+// Package multi provide an implementation of the sender interface that sends the same json
+// objects to several senders (tee), e.g. a staging and a production service, or a service and a file.
+package multi // import "goex/ltser/sender/multi"
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goex/ltser/sender"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Mode is when the sending of an object to several targets succeeds.
+type Mode byte
+
+// Available modes.
+const (
+	All     Mode = iota // Every target must succeed.
+	Any                 // At least one target must succeed.
+	Primary             // The first target must succeed, the others are best effort.
+)
+
+const defMode = All
+
+// A Sender sends each json object to all its targets, concurrently, and waits for them. Whether the
+// object is sent depends on Mode; the failures of each target are counted in any case (see Failures).
+//
+// When the object is not sent, the error is an *Error with the failures of the targets. If all the
+// failed targets rejected the object, it is marked as a rejection (see sender.Rejected); if they all
+// returned a *sender.BatchError, these are merged in a single *sender.BatchError.
+//
+// With Mode Any, a *sender.BatchError is a partial success: the objects of a batch (a JSON array or
+// NDJSON) that a target accepted are sent. The error is then a *sender.BatchError of the objects
+// failed by every target, nil if there are none.
+//
+// A target that already received an object receives it again if it is sent again after a failure
+// of another target.
+type Sender struct {
+	Mode     Mode
+	Names    []string // Names of the targets in the errors. If missing, "#1", "#2" and so on.
+	targets  []sender.Sender
+	failures []uint64 // Accessed atomically.
+}
+
+// An Error reports the failures of the targets of an object that was not sent.
+type Error struct {
+	Errors []*TargetError
+}
+
+// A TargetError is the failure of a target, by index in the targets.
+type TargetError struct {
+	Index int
+	Name  string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, te := range e.Errors {
+		msgs[i] = te.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("target %s: %v", e.Name, e.Err)
+}
+
+func (e *TargetError) Unwrap() error {
+	return e.Err
+}
+
+// NewSender returns a new Sender to targets. With mode Primary, the first one is the primary target.
+func NewSender(targets ...sender.Sender) *Sender {
+	multiSender := new(Sender)
+	multiSender.Mode = defMode
+	multiSender.targets = targets
+	multiSender.failures = make([]uint64, len(targets))
+
+	return multiSender
+}
+
+// ParseMode returns the mode by name: "all", "any" or "primary".
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "all":
+		return All, nil
+	case "any":
+		return Any, nil
+	case "primary":
+		return Primary, nil
+	}
+	return 0, fmt.Errorf("unknown mode %q", s)
+}
+
+// Send sends a json object to the targets.
+func (s *Sender) Send(b []byte) error {
+	return s.SendContext(context.Background(), b)
+}
+
+// SendContext sends a json object to the targets, passing them the context (see sender.WithContext).
+func (s *Sender) SendContext(ctx context.Context, b []byte) error {
+	errs := make([]error, len(s.targets))
+
+	var wg sync.WaitGroup
+	for i, t := range s.targets {
+		wg.Add(1)
+		go func(i int, t sender.Sender) {
+			defer wg.Done()
+			errs[i] = sender.WithContext(t).SendContext(ctx, b)
+			if errs[i] != nil {
+				atomic.AddUint64(&s.failures[i], 1)
+			}
+		}(i, t)
+	}
+	wg.Wait()
+
+	me := new(Error)
+	for i, err := range errs {
+		if err != nil {
+			me.Errors = append(me.Errors, &TargetError{Index: i, Name: s.name(i), Err: err})
+		}
+	}
+
+	switch {
+	case len(me.Errors) == 0:
+		return nil
+	case s.Mode == Any && len(me.Errors) < len(s.targets):
+		return nil
+	case s.Mode == Any:
+		return s.combineAny(me)
+	case s.Mode == Primary && errs[0] == nil:
+		return nil
+	case s.Mode == Primary:
+		me.Errors = me.Errors[:1] // The failures of the others do not matter.
+	}
+	return s.combine(me)
+}
+
+// Failures returns the number of failures of each target so far.
+func (s *Sender) Failures() []uint64 {
+	failures := make([]uint64, len(s.failures))
+	for i := range s.failures {
+		failures[i] = atomic.LoadUint64(&s.failures[i])
+	}
+	return failures
+}
+
+// Retries returns the sum of the retries of the targets that count them (e.g. the http sender).
+func (s *Sender) Retries() uint64 {
+	var retries uint64
+	for _, t := range s.targets {
+		if rc, ok := t.(interface{ Retries() uint64 }); ok {
+			retries += rc.Retries()
+		}
+	}
+	return retries
+}
+
+// Close closes the targets that have something to close, and returns the first error.
+func (s *Sender) Close() error {
+	var err error
+	for _, t := range s.targets {
+		if c, ok := t.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+func (s *Sender) name(i int) string {
+	if i < len(s.Names) && s.Names[i] != "" {
+		return s.Names[i]
+	}
+	return fmt.Sprintf("#%v", i+1)
+}
+
+// combine returns the error of an object not sent: the merged batch errors, the rejection or the failure of the targets.
+func (s *Sender) combine(me *Error) error {
+	merged := new(sender.BatchError)
+	byIndex := make(map[int]*sender.IndexError)
+	rejected := true
+	for _, te := range me.Errors {
+		var be *sender.BatchError
+		if merged != nil && errors.As(te.Err, &be) {
+			for _, ie := range be.Errors {
+				msg := fmt.Sprintf("target %s: %s", te.Name, ie.Err)
+				if prev, ok := byIndex[ie.Index]; ok {
+					prev.Err += "; " + msg
+					continue
+				}
+				byIndex[ie.Index] = &sender.IndexError{Index: ie.Index, Err: msg}
+				merged.Errors = append(merged.Errors, byIndex[ie.Index])
+			}
+		} else {
+			merged = nil
+		}
+		rejected = rejected && sender.IsRejected(te.Err)
+	}
+
+	switch {
+	case merged != nil:
+		return merged
+	case rejected:
+		return sender.Rejected(me)
+	}
+	return me
+}
+
+// combineAny returns the error of an object that every target failed, in Mode Any. If some targets
+// returned a *sender.BatchError, only the objects of the batch failed by every target are reported;
+// the other targets failed the whole batch.
+func (s *Sender) combineAny(me *Error) error {
+	var batchErrs []*sender.BatchError
+	var batchNames, otherMsgs []string
+	for _, te := range me.Errors {
+		var be *sender.BatchError
+		if errors.As(te.Err, &be) {
+			batchErrs = append(batchErrs, be)
+			batchNames = append(batchNames, te.Name)
+		} else {
+			otherMsgs = append(otherMsgs, te.Error())
+		}
+	}
+	if len(batchErrs) == 0 {
+		return s.combine(me)
+	}
+
+	msgs := make(map[int][]string) // Of the objects, by index, from the batch errors.
+	for i, be := range batchErrs {
+		for _, ie := range be.Errors {
+			msgs[ie.Index] = append(msgs[ie.Index], fmt.Sprintf("target %s: %s", batchNames[i], ie.Err))
+		}
+	}
+
+	failed := new(sender.BatchError)
+	for _, ie := range batchErrs[0].Errors { // Failed by every target, so by the first one.
+		if m := msgs[ie.Index]; len(m) == len(batchErrs) {
+			failed.Errors = append(failed.Errors, &sender.IndexError{Index: ie.Index, Err: strings.Join(append(m, otherMsgs...), "; ")})
+			delete(msgs, ie.Index) // Once, even if listed twice.
+		}
+	}
+	if len(failed.Errors) == 0 {
+		return nil
+	}
+	return failed
+}
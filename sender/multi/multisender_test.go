@@ -0,0 +1,82 @@
+package multi_test
+
+import (
+	"errors"
+	"goex/ltser/sender"
+	multisender "goex/ltser/sender/multi"
+	"reflect"
+	"testing"
+)
+
+// A fakeSender returns the same error for every object.
+type fakeSender struct {
+	err error
+}
+
+func (f fakeSender) Send(b []byte) error {
+	return f.err
+}
+
+func TestSend(t *testing.T) {
+	ok := fakeSender{}
+	down := fakeSender{errors.New("unavailable")}
+	invalid := fakeSender{sender.Rejected(errors.New("invalid"))}
+	batch := fakeSender{&sender.BatchError{Errors: []*sender.IndexError{{Index: 1, Err: "invalid"}}}}
+	batch2 := fakeSender{&sender.BatchError{Errors: []*sender.IndexError{{Index: 2, Err: "invalid"}}}}
+	batch12 := fakeSender{&sender.BatchError{Errors: []*sender.IndexError{{Index: 1, Err: "invalid"}, {Index: 2, Err: "invalid"}}}}
+
+	var tests = []struct {
+		mode         multisender.Mode
+		targets      []sender.Sender
+		wantErr      bool
+		wantRejected bool
+		wantBatch    []int // Indexes of the merged batch error.
+	}{
+		{multisender.All, []sender.Sender{ok, ok}, false, false, nil},
+		{multisender.All, []sender.Sender{ok, down}, true, false, nil},
+		{multisender.All, []sender.Sender{invalid, ok, invalid}, true, true, nil},
+		{multisender.All, []sender.Sender{invalid, down}, true, false, nil},
+		{multisender.All, []sender.Sender{batch, ok, batch}, true, false, []int{1}},
+		{multisender.Any, []sender.Sender{down, ok}, false, false, nil},
+		{multisender.Any, []sender.Sender{down, invalid}, true, false, nil},
+		{multisender.Any, []sender.Sender{batch, batch2}, false, false, nil}, // Each object accepted by a target.
+		{multisender.Any, []sender.Sender{batch12, batch2}, true, false, []int{2}},
+		{multisender.Any, []sender.Sender{batch2, batch12, batch12}, true, false, []int{2}},
+		{multisender.Any, []sender.Sender{batch, down}, true, false, []int{1}}, // The others taken by batch.
+		{multisender.Any, []sender.Sender{down, batch12, invalid}, true, false, []int{1, 2}},
+		{multisender.Any, []sender.Sender{batch, ok}, false, false, nil},
+		{multisender.All, []sender.Sender{batch, batch2}, true, false, []int{1, 2}},
+		{multisender.Primary, []sender.Sender{ok, down}, false, false, nil},
+		{multisender.Primary, []sender.Sender{invalid, down}, true, true, nil},
+	}
+
+	for _, tt := range tests {
+		s := multisender.NewSender(tt.targets...)
+		s.Mode = tt.mode
+		err := s.Send([]byte(`{}`))
+
+		var be *sender.BatchError
+		var gotBatch []int
+		if errors.As(err, &be) {
+			for _, ie := range be.Errors {
+				gotBatch = append(gotBatch, ie.Index)
+			}
+		}
+		if (err != nil) != tt.wantErr || sender.IsRejected(err) != tt.wantRejected || !reflect.DeepEqual(gotBatch, tt.wantBatch) {
+			t.Errorf("Send() with mode %v and targets %v = %v, want error %v (rejected %v, batch %v)",
+				tt.mode, tt.targets, err, tt.wantErr, tt.wantRejected, tt.wantBatch)
+		}
+
+		var want []uint64
+		for _, target := range tt.targets {
+			if target.(fakeSender).err != nil {
+				want = append(want, 1)
+			} else {
+				want = append(want, 0)
+			}
+		}
+		if got := s.Failures(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Failures() with targets %v = %v, want %v", tt.targets, got, want)
+		}
+	}
+}
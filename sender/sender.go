@@ -1,7 +1,98 @@
 // Package sender provide an interface to send json data to a target.
 package sender // import "goex/ltser/sender"
 
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
 // A Sender send json objects to a target.
 type Sender interface {
 	Send(b []byte) error
 }
+
+// A ContextSender send json objects to a target, giving up when the context is done
+// (e.g. cancelled on shutdown, or past a deadline).
+type ContextSender interface {
+	Sender
+	SendContext(ctx context.Context, b []byte) error
+}
+
+// WithContext returns s as a ContextSender: s itself if it is one, otherwise an adapter whose
+// SendContext returns the error of the context as soon as it is done. The Send of s can't be
+// cancelled, though: the object may be sent anyway.
+func WithContext(s Sender) ContextSender {
+	if cs, ok := s.(ContextSender); ok {
+		return cs
+	}
+	return contextAdapter{s}
+}
+
+type contextAdapter struct {
+	Sender
+}
+
+func (a contextAdapter) SendContext(ctx context.Context, b []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- a.Send(b) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// A BatchError reports the objects of a batch (a JSON array or NDJSON) rejected by the target,
+// the others having been accepted. It is also the JSON body of the responses to such batches.
+type BatchError struct {
+	Errors []*IndexError `json:"errors"`
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("1 object of the batch rejected (%s)", e.Errors[0])
+	}
+	return fmt.Sprintf("%v objects of the batch rejected", len(e.Errors))
+}
+
+// An IndexError is the error of an object of a batch, by index in the batch.
+type IndexError struct {
+	Index int    `json:"index"`
+	Err   string `json:"error"`
+}
+
+func (e *IndexError) Error() string {
+	return fmt.Sprintf("object #%v: %s", e.Index, e.Err)
+}
+
+// Rejected marks err as the rejection by the target of the objects sent: sending them
+// again would fail again (e.g. they are invalid), but other objects can be sent.
+func Rejected(err error) error {
+	return &rejectedError{err}
+}
+
+// IsRejected reports whether err, or an error it wraps, marks a rejection (see Rejected).
+// The errors of the objects of a batch (IndexError) are rejections.
+func IsRejected(err error) bool {
+	var re *rejectedError
+	var ie *IndexError
+	return errors.As(err, &re) || errors.As(err, &ie)
+}
+
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectedError) Unwrap() error {
+	return e.err
+}
@@ -2,6 +2,7 @@
 package stdout // import "goex/ltser/sender/stdout"
 
 import (
+	"context"
 	"fmt"
 	"os"
 )
@@ -19,6 +20,14 @@ func NewSender() *Sender {
 
 // Send write json objects to StdOut.
 func (s *Sender) Send(b []byte) error {
+	return s.SendContext(context.Background(), b)
+}
+
+// SendContext write json objects to StdOut, unless the context is done.
+func (s *Sender) SendContext(ctx context.Context, b []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	_, err := fmt.Fprintf(os.Stdout, "%s\n", b)
 
 	return err